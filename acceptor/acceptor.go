@@ -3,47 +3,103 @@ package main
 import (
     "flag"
     "fmt"
+    "io"
     "path"
     "os"
     "os/signal"
     "strconv"
+    "sync"
+    "time"
 
     "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/config"
     "github.com/quickfixgo/quickfix/enum"
     "github.com/quickfixgo/quickfix/field"
     "github.com/quickfixgo/quickfix/tag"
     "github.com/shopspring/decimal"
 
-    "github.com/btasdoven/go-finance"
-
     fix42nos "github.com/quickfixgo/quickfix/fix42/newordersingle"
     fix42osr "github.com/quickfixgo/quickfix/fix42/orderstatusrequest"
     fix42er "github.com/quickfixgo/quickfix/fix42/executionreport"
     fix42mdr "github.com/quickfixgo/quickfix/fix42/marketdatarequest"
-    fix42md  "github.com/quickfixgo/quickfix/fix42/marketdatasnapshotfullrefresh"
-    "sort"
+
+    "github.com/btasdoven/quickfixwebclient/acceptor/orderbook"
 )
 
+//executor is the simulated exchange. quickfix calls back from one goroutine per session and the expiry
+//sweeper and market makers run on their own, so every field below lock is only touched with lock held.
 type executor struct {
+    *quickfix.MessageRouter
+    lock sync.Mutex
+
     orderID int
     execID  int
-    *quickfix.MessageRouter
-    quotes map[string]*Quote
-    orders []*Order
+    quotes   map[string]*Quote
+    orders   []*Order
+    orderIDs map[string]*Order
+    clOrdIDs map[orderKey]*Order
+    resting  map[string]*Order
+    stops    map[string][]*Order
+    pegs     map[string][]*Order
+
+    //closeOrders are the orders at the close waiting for the closing call of their symbol
+    closeOrders map[string][]*Order
+
+    subscriptions map[subscriptionKey]*subscription
+
+    prices      PriceSource
+    marketMaker *marketMakerRules
+    marketClose marketClose
+    risk        *riskRules
+    selfTrade   *selfTradeRules
+    phases      *phaseRules
+
+    //journal records every order event when a JournalPath is set, replaying is true while it is read back
+    journal   *journal
+    replaying bool
+
+    //send delivers a message to a session, quickfix.SendToTarget unless replaced by a test or a replay
+    send func(m quickfix.Messagable, sessionID quickfix.SessionID) error
+
+    //log is where the executor tells what it does, os.Stdout unless a replay keeps it quiet
+    log io.Writer
+
+    //now is the clock of the executor, time.Now unless a replay drives it. makers are the running market
+    //makers, manual is set when they move with the clock rather than on their own.
+    now    func() time.Time
+    makers []*marketMaker
+    manual bool
 }
 
 type Order struct {
+    OrderID       string
     ClOrdID       string
+    OrigClOrdID   string
     ExecID        string
     ExecType      enum.ExecType
     ExecTransType enum.ExecTransType
     OrderStatus   enum.OrdStatus
     OrdType       enum.OrdType
+    Triggered     bool
+    ExecInst      enum.ExecInst
     Side          enum.Side
     Symbol        string
+    Account       string
+    SessionID     quickfix.SessionID
+    TimeInForce   enum.TimeInForce
+    ExpireTime    time.Time
+    OrdRejReason  enum.OrdRejReason
+    Text          string
 
     Price       decimal.Decimal
+    StopPx      decimal.Decimal
     OrderQty    decimal.Decimal
+    MinQty      decimal.Decimal
+    MaxFloor    decimal.Decimal
+
+    PegDifference    decimal.Decimal
+    PeggedPrice      decimal.Decimal
+    DiscretionOffset decimal.Decimal
 
     LeavesQty   decimal.Decimal
     CumQty      decimal.Decimal
@@ -54,23 +110,13 @@ type Order struct {
     LastShares  decimal.Decimal
 }
 
+//Process applies to order a fill of quantity at price made by the order book, which alone decides what trades
 func (o *Order) Process(price decimal.Decimal, quantity decimal.Decimal) {
-    if (o.Side == enum.Side_BUY && o.Price.Cmp(price) < 0) ||
-        (o.Side == enum.Side_SELL && o.Price.Cmp(price) > 0) {
-        return
-    }
-
-    qtyToProcess := decimal.Min(o.LeavesQty, quantity)
-
-    if qtyToProcess.Cmp(decimal.Zero) <= 0 {
-        return
-    }
-
     o.LastPrice = price
-    o.LastShares = qtyToProcess
-    o.CumQty = o.CumQty.Add(qtyToProcess)
-    o.LeavesQty = o.LeavesQty.Sub(qtyToProcess)
-    o.TotalPrice.Add(price.Mul(qtyToProcess))
+    o.LastShares = quantity
+    o.CumQty = o.CumQty.Add(quantity)
+    o.LeavesQty = o.LeavesQty.Sub(quantity)
+    o.TotalPrice = o.TotalPrice.Add(price.Mul(quantity))
     o.AvgPx = o.TotalPrice.Div(o.CumQty)
 
     if o.CumQty.Equals(decimal.Zero) {
@@ -82,87 +128,167 @@ func (o *Order) Process(price decimal.Decimal, quantity decimal.Decimal) {
     }
 }
 
-type BidAsk struct {
-    price   decimal.Decimal
-    size    decimal.Decimal
-    order   *Order
+//printf logs what the executor does
+func (e *executor) printf(format string, a ...interface{}) {
+    fmt.Fprintf(e.log, format, a...)
 }
 
-type AskList []BidAsk
-type BidList []BidAsk
-
-func (a AskList) Len() int           { return len(a) }
-func (a AskList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a AskList) Less(i, j int) bool { return a[i].price.Cmp(a[j].price) < 0 }
-
-func (a BidList) Len() int           { return len(a) }
-func (a BidList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a BidList) Less(i, j int) bool { return a[i].price.Cmp(a[j].price) > 0 }
-
-
 func (e *executor) DumpOrders() {
-    fmt.Printf("\n---------------------------------------\n")
+    e.printf("\n---------------------------------------\n")
     for _, q := range e.quotes {
-        fmt.Printf("\t%v\n", q.symbol)
-        for i := len(q.bids) - 1; i >= 0; i-- {
-            fmt.Printf("\t\t(%v %v) %+v\n", q.bids[i].price, q.bids[i].size, q.bids[i].order)
+        e.printf("\t%v\n", q.symbol)
+        bids := q.book.Orders(orderbook.Buy)
+        for i := len(bids) - 1; i >= 0; i-- {
+            e.printf("\t\t(%v %v) %+v\n", bids[i].Price, bids[i].Quantity, e.resting[bids[i].ID])
         }
-        fmt.Printf("\t\t--> %v %v %v\n", q.trade.price, q.trade.size, q.trade.order)
+        e.printf("\t\t--> %v %v\n", q.trade.price, q.trade.size)
 
-        for _, a := range q.asks {
-            fmt.Printf("\t\t(%v %v) %+v\n", a.price, a.size, a.order)
+        for _, a := range q.book.Orders(orderbook.Sell) {
+            e.printf("\t\t(%v %v) %+v\n", a.Price, a.Quantity, e.resting[a.ID])
         }
 
-        fmt.Printf("\n")
+        e.printf("\n")
     }
 
     for _, o := range e.orders {
-        fmt.Printf("order: %+v\n", o)
+        e.printf("order: %+v\n", o)
     }
 
-    fmt.Printf("\n\n")
+    e.printf("\n\n")
 }
 
 type Quote struct {
     symbol      string
-    trade       BidAsk
-    bids        []BidAsk
-    asks        []BidAsk
+    trade       mdLevel
+    book        *orderbook.Book
+
+    //schedule is the trading day of the symbol, phase where it stands in it. open and close are the results
+    //of the last opening and closing auctions.
+    schedule    *schedule
+    phase       phase
+    open        mdLevel
+    close       mdLevel
 }
 
-func (e *executor) getQuote(symbol string) *Quote {
-    fmt.Printf("---Symbol--- %v --- %v\n", symbol, len(e.quotes))
+//bookSide maps a FIX side onto the side of the order book
+func bookSide(side enum.Side) orderbook.Side {
+    if side == enum.Side_BUY {
+        return orderbook.Buy
+    }
+    return orderbook.Sell
+}
+
+//bookOrder is what the order book sees of order
+func (o *Order) bookOrder() orderbook.Order {
+    return orderbook.Order{
+        ID:        o.OrderID,
+        Side:      bookSide(o.Side),
+        Market:    o.OrdType == enum.OrdType_MARKET,
+        Price:     o.bookPrice(),
+        Quantity:  o.LeavesQty,
+        //market orders are immediate outside of calls, the book knows
+        Immediate: o.TimeInForce == enum.TimeInForce_IMMEDIATE_OR_CANCEL || o.TimeInForce == enum.TimeInForce_FILL_OR_KILL,
+        Display:   o.MaxFloor,
+
+        MinQuantity: o.MinQty,
+        AllOrNone:   o.isAllOrNone(),
+        Discretion:  o.DiscretionOffset,
+        Pegged:      o.isPegged(),
+    }
+}
+
+//unbook takes order out of its book
+func (e *executor) unbook(order *Order) {
+    e.quotes[order.Symbol].book.Cancel(order.OrderID)
+    delete(e.resting, order.OrderID)
+}
+
+func (e *executor) getQuote(symbol string) (*Quote, error) {
+    e.printf("---Symbol--- %v --- %v\n", symbol, len(e.quotes))
     if _, ok := e.quotes[symbol]; !ok {
-        stock, _ := finance.GetQuote(symbol)
-        e.quotes[symbol] = &Quote{
-            symbol: symbol,
-            trade: BidAsk{
-                price: stock.LastTradePrice,
-                size: decimal.New(int64(stock.LastTradeSize), 0)},
-            asks: make([]BidAsk, 2),
-            bids: make([]BidAsk, 1),
+        price, err := e.prices.GetPrice(symbol)
+        if err != nil {
+            return nil, err
+        }
+
+        stock := &Quote{symbol: symbol, trade: mdLevel{price: price.Last, size: price.LastSize}, book: orderbook.New()}
+        e.quotes[symbol] = stock
+
+        stock.schedule = e.phases.of(symbol)
+        stock.phase = stock.schedule.at(e.now())
+        //a journal is replayed into books that do not match, its trades are replayed instead
+        if stock.phase.isCall() || e.replaying {
+            stock.book.Call()
         }
 
-        e.quotes[symbol].asks[0].price = stock.Ask
-        e.quotes[symbol].bids[0].price = stock.Bid
-        e.quotes[symbol].asks[0].size = decimal.New(int64(stock.AskSize), 0)
-        e.quotes[symbol].bids[0].size = decimal.New(int64(stock.BidSize), 0)
+        if e.marketMaker.of(symbol) != nil {
+            //the books of a journal are rebuilt before any market maker trades in them
+            if !e.replaying {
+                e.startMarketMaker(stock)
+            }
+            return stock, nil
+        }
+
+        //without a market maker the book is seeded with anonymous liquidity around the reference price
+        stock.book.Submit(orderbook.Order{ID: symbol + "-BID", Side: orderbook.Buy, Price: price.Bid, Quantity: price.BidSize})
+        stock.book.Submit(orderbook.Order{ID: symbol + "-ASK-1", Side: orderbook.Sell, Price: price.Ask, Quantity: price.AskSize})
+        stock.book.Submit(orderbook.Order{ID: symbol + "-ASK-2", Side: orderbook.Sell, Price: price.Ask.Add(decimal.New(4, 0)), Quantity: decimal.New(12, 0)})
+    }
+
+    return e.quotes[symbol], nil
+}
 
-        e.quotes[symbol].asks[1].price = stock.Ask.Add(decimal.New(4, 0))
-        e.quotes[symbol].asks[1].size = decimal.New(12, 0)
+//startMarketMaker quotes stock around its reference price, if the acceptor runs market makers
+func (e *executor) startMarketMaker(stock *Quote) error {
+    config := e.marketMaker.of(stock.symbol)
+    if config == nil {
+        return nil
     }
 
-    return e.quotes[symbol]
+    price, err := e.prices.GetPrice(stock.symbol)
+    if err != nil {
+        return err
+    }
+
+    mid := price.Last
+    if price.Bid.Cmp(decimal.Zero) > 0 && price.Ask.Cmp(decimal.Zero) > 0 {
+        mid = price.Bid.Add(price.Ask).Div(decimal.New(2, 0))
+    }
+
+    maker := newMarketMaker(config, stock, mid)
+    maker.due = e.now().Add(maker.interval)
+    e.makers = append(e.makers, maker)
+
+    e.refresh(maker)
+    if !e.manual {
+        go e.run(maker)
+    }
+    return nil
 }
 
-func newExecutor() *executor {
-    e := &executor{MessageRouter: quickfix.NewMessageRouter()}
+func newExecutor(settings *quickfix.SessionSettings, prices PriceSource) (e *executor, err error) {
+    e = &executor{MessageRouter: quickfix.NewMessageRouter(), prices: prices, send: quickfix.SendToTarget, now: time.Now, log: os.Stdout}
     e.AddRoute(fix42nos.Route(e.OnFIX42NewOrderSingle))
     e.AddRoute(fix42mdr.Route(e.OnFIX42MarketDataRequest))
     e.AddRoute(fix42osr.Route(e.OnFIX42OrderStatusRequest))
+    e.AddRoute(enum.BeginStringFIX42, string(enum.MsgType_ORDER_CANCEL_REQUEST), e.OnFIX42OrderCancelRequest)
+    e.AddRoute(enum.BeginStringFIX42, string(enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST), e.OnFIX42OrderCancelReplaceRequest)
 
     e.quotes = make(map[string]*Quote)
-    return e
+    e.orderIDs = make(map[string]*Order)
+    e.clOrdIDs = make(map[orderKey]*Order)
+    e.resting = make(map[string]*Order)
+    e.stops = make(map[string][]*Order)
+    e.pegs = make(map[string][]*Order)
+    e.closeOrders = make(map[string][]*Order)
+    e.subscriptions = make(map[subscriptionKey]*subscription)
+
+    if e.marketMaker, err = newMarketMakerRules(settings, nil); err != nil {
+        return
+    }
+
+    e.marketClose, err = newMarketClose(settings)
+    return
 }
 
 func (e *executor) genOrderID() field.OrderIDField {
@@ -175,81 +301,172 @@ func (e *executor) genExecID() field.ExecIDField {
     return field.NewExecID(strconv.Itoa(e.execID))
 }
 
-//quickfix.Application interface
-func (e executor) OnCreate(sessionID quickfix.SessionID)                           { return }
-func (e executor) OnLogon(sessionID quickfix.SessionID)                            { return }
-func (e executor) OnLogout(sessionID quickfix.SessionID)                           { return }
-func (e executor) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionID)     { return }
-func (e executor) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) error { return nil }
-func (e executor) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
-    return nil
+//cancel takes whatever is left of order off the market
+func (e *executor) cancel(order *Order) {
+    order.LeavesQty = decimal.Zero
+    order.LastPrice = decimal.Zero
+    order.LastShares = decimal.Zero
+    order.OrderStatus = enum.OrdStatus_CANCELED
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_CANCELED
+    order.ExecID = e.genExecID().Value()
+
+    e.record(eventCancel, order)
 }
 
-//Use Message Cracker on Incoming Application Messages
-func (e *executor) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
-    fmt.Printf("Received %v\n", msg)
-    return e.Route(msg, sessionID)
+//orderKey identifies an order the way its owner does, ClOrdIDs are only unique within a session
+type orderKey struct {
+    sessionID quickfix.SessionID
+    clOrdID   string
 }
 
-func (e *executor) OnFIX42MarketDataRequest(msg fix42mdr.MarketDataRequest, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
-    fmt.Printf("[SERVER] - MDR: %+v\n", msg.Message)
+//findOrder returns the order sessionID knows as clOrdID, nil if that session has no such order
+func (e *executor) findOrder(sessionID quickfix.SessionID, clOrdID string) *Order {
+    return e.clOrdIDs[orderKey{sessionID: sessionID, clOrdID: clOrdID}]
+}
 
-    mdReqID, _ := msg.GetMDReqID()
+//index makes order known under its OrderID and current ClOrdID, the ClOrdIDs it had before stay known.
+//Orders refused before they were accepted are only known by their ClOrdID.
+func (e *executor) index(order *Order) {
+    if order.OrderID != "" {
+        e.orderIDs[order.OrderID] = order
+    }
+    e.clOrdIDs[orderKey{sessionID: order.SessionID, clOrdID: order.ClOrdID}] = order
+}
 
-    noRelatedSym, _ := msg.GetNoRelatedSym()
+func newExecutionReport(order *Order) fix42er.ExecutionReport {
+    //orders refused before the exchange accepted them have no OrderID of their own
+    orderID := order.OrderID
+    if orderID == "" {
+        orderID = "NONE"
+    }
 
-    length := noRelatedSym.Len()
+    execReport := fix42er.New(
+        field.NewOrderID(orderID),
+        field.NewExecID(order.ExecID),
+        field.NewExecTransType(order.ExecTransType),
+        field.NewExecType(order.ExecType),
+        field.NewOrdStatus(order.OrderStatus),
+        field.NewSymbol(order.Symbol),
+        field.NewSide(order.Side),
+        field.NewLeavesQty(order.LeavesQty, 2),
+        field.NewCumQty(order.CumQty, 2),
+        field.NewAvgPx(order.AvgPx, 2),
+    )
 
-    if length <= 0 {
-        return
+    execReport.SetClOrdID(order.ClOrdID)
+    execReport.SetOrderQty(order.OrderQty, 2)
+    execReport.SetLastShares(order.LastShares, 2)
+    execReport.SetLastPx(order.LastPrice, 2)
+
+    if order.OrigClOrdID != "" {
+        execReport.SetOrigClOrdID(order.OrigClOrdID)
     }
 
-    symbol, _ := noRelatedSym.Get(0).GetSymbol()
-    fmt.Printf("\tSymbol: %+v\n", symbol)
+    if order.MaxFloor.Cmp(decimal.Zero) > 0 {
+        execReport.SetMaxFloor(order.MaxFloor, 2)
+    }
 
-    stock := e.getQuote(symbol)
-    fmt.Printf("\tStock: %+v\n", stock)
+    if order.MinQty.Cmp(decimal.Zero) > 0 {
+        execReport.SetMinQty(order.MinQty, 2)
+    }
 
-    noMDEntryTypes, _ := msg.GetNoMDEntryTypes()
-    noMDEntries := fix42md.NewNoMDEntriesRepeatingGroup()
+    if order.ExecInst != "" {
+        execReport.SetExecInst(order.ExecInst)
+    }
 
-    for i := 0; i < noMDEntryTypes.Len(); i++ {
-        noMDEntryType, _ := noMDEntryTypes.Get(i).GetMDEntryType()
-        noMDEntries.Add().SetMDEntryType(noMDEntryType)
+    //a pegged order reports the price it is pegged to
+    if order.isPegged() && order.PeggedPrice.Cmp(decimal.Zero) > 0 {
+        execReport.SetPrice(order.PeggedPrice, 2)
+    }
 
-        switch noMDEntryType {
-        case enum.MDEntryType_BID:
-            bid := BidAsk{}
-            if len(stock.bids) > 0 {
-                bid = stock.bids[0]
-            }
-            noMDEntries.Get(i).SetMDEntryPx(bid.price, 5)
-            noMDEntries.Get(i).SetMDEntrySize(bid.size, 5)
-        case enum.MDEntryType_OFFER:
-            ask := BidAsk{}
-            if len(stock.asks) > 0 {
-                ask = stock.asks[0]
-            }
-            noMDEntries.Get(i).SetMDEntryPx(ask.price, 5)
-            noMDEntries.Get(i).SetMDEntrySize(ask.size, 5)
-        case enum.MDEntryType_TRADE:
-            noMDEntries.Get(i).SetMDEntryPx(stock.trade.price, 5)
-            noMDEntries.Get(i).SetMDEntrySize(stock.trade.size, 5)
-        }
+    if !order.PegDifference.Equals(decimal.Zero) {
+        execReport.SetPegDifference(order.PegDifference, 2)
+    }
+
+    if order.DiscretionOffset.Cmp(decimal.Zero) > 0 {
+        execReport.SetDiscretionInst(enum.DiscretionInst_RELATED_TO_DISPLAYED_PRICE)
+        execReport.SetDiscretionOffset(order.DiscretionOffset, 2)
     }
 
-    md := fix42md.New(field.NewSymbol(symbol))
-    md.SetMDReqID(mdReqID)    
-    md.SetNoMDEntries(noMDEntries)    
+    if order.Account != "" {
+        execReport.SetAccount(order.Account)
+    }
 
-    fmt.Printf("\tSending %+v", md)
-    quickfix.SendToTarget(md, sessionID)
+    if order.TimeInForce != "" {
+        execReport.SetTimeInForce(order.TimeInForce)
+    }
 
-    e.DumpOrders()
+    if order.OrdRejReason != "" {
+        execReport.SetOrdRejReason(order.OrdRejReason)
+    }
 
+    if order.Text != "" {
+        execReport.SetText(order.Text)
+    }
+
+    return execReport
+}
+
+//quickfix.Application interface
+func (e *executor) OnCreate(sessionID quickfix.SessionID)                           { return }
+func (e *executor) OnLogon(sessionID quickfix.SessionID)                            { return }
+func (e *executor) OnLogout(sessionID quickfix.SessionID) {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+    e.unsubscribeAll(sessionID)
+}
+func (e *executor) ToAdmin(msg *quickfix.Message, sessionID quickfix.SessionID)     { return }
+func (e *executor) ToApp(msg *quickfix.Message, sessionID quickfix.SessionID) error { return nil }
+func (e *executor) FromAdmin(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+    return nil
+}
+
+//Use Message Cracker on Incoming Application Messages
+func (e *executor) FromApp(msg *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+    e.printf("Received %v\n", msg)
+
+    e.lock.Lock()
+    defer e.lock.Unlock()
+
+    reject = e.Route(msg, sessionID)
+    e.publishMarketData()
     return
 }
 
+//report applies the fills of order to it and to the resting orders it traded with. Every level traded is
+//reported as a separate fill, to the aggressor and to the owner of the resting order.
+func (e *executor) report(stock *Quote, order *Order, fills []orderbook.Fill) {
+    for _, fill := range fills {
+        e.recordTrade(stock, fill)
+
+        if fill.SelfTrade {
+            e.preventSelfTrade(order, fill.AggressorQuantity)
+            if resting, ok := e.resting[fill.Resting]; ok {
+                e.preventSelfTrade(resting, fill.Quantity)
+                if !resting.isWorking() {
+                    delete(e.resting, fill.Resting)
+                }
+            }
+            continue
+        }
+
+        order.Process(fill.Price, fill.Quantity)
+        e.sendFill(order)
+
+        stock.trade = mdLevel{price: fill.Price, size: fill.Quantity}
+
+        if resting, ok := e.resting[fill.Resting]; ok {
+            resting.Process(fill.Price, fill.Quantity)
+            e.sendFill(resting)
+
+            if resting.OrderStatus == enum.OrdStatus_FILLED {
+                delete(e.resting, fill.Resting)
+            }
+        }
+    }
+}
+
 func (e *executor) OnFIX42NewOrderSingle(msg fix42nos.NewOrderSingle, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
 
     var order Order
@@ -259,7 +476,9 @@ func (e *executor) OnFIX42NewOrderSingle(msg fix42nos.NewOrderSingle, sessionID
         return err
     }
 
-    if order.OrdType != enum.OrdType_LIMIT {
+    switch order.OrdType {
+    case enum.OrdType_LIMIT, enum.OrdType_MARKET, enum.OrdType_STOP, enum.OrdType_STOP_LIMIT, enum.OrdType_PEGGED:
+    default:
         return quickfix.ValueIsIncorrect(tag.OrdType)
     }
 
@@ -278,9 +497,32 @@ func (e *executor) OnFIX42NewOrderSingle(msg fix42nos.NewOrderSingle, sessionID
         return
     }
 
-    order.Price, err = msg.GetPrice()
-    if err != nil {
-        return
+    if order.OrdType == enum.OrdType_LIMIT || order.OrdType == enum.OrdType_STOP_LIMIT {
+        order.Price, err = msg.GetPrice()
+        if err != nil {
+            return
+        }
+    }
+
+    if order.OrdType == enum.OrdType_STOP || order.OrdType == enum.OrdType_STOP_LIMIT {
+        order.StopPx, err = msg.GetStopPx()
+        if err != nil {
+            return
+        }
+    }
+
+    //the Price of a pegged order is optional, it is the limit the peg never goes past
+    if order.isPegged() {
+        if msg.HasPrice() {
+            if order.Price, err = msg.GetPrice(); err != nil {
+                return
+            }
+        }
+        if msg.HasPegDifference() {
+            if order.PegDifference, err = msg.GetPegDifference(); err != nil {
+                return
+            }
+        }
     }
 
     order.ClOrdID, err = msg.GetClOrdID()
@@ -288,151 +530,215 @@ func (e *executor) OnFIX42NewOrderSingle(msg fix42nos.NewOrderSingle, sessionID
         return
     }
 
-    stock := e.getQuote(order.Symbol)
-    order.LeavesQty = order.OrderQty
-    order.OrderStatus = enum.OrdStatus_NEW
-    order.ExecTransType = enum.ExecTransType_NEW
-    order.ExecType = enum.ExecType_FILL
-    order.TotalPrice = decimal.Zero
-    order.LastPrice = decimal.Zero
-    order.LastShares = decimal.Zero
-    order.ExecID = e.genExecID().Value()
+    if msg.HasAccount() {
+        order.Account, err = msg.GetAccount()
+        if err != nil {
+            return
+        }
+    }
 
-    switch order.Side {
-    case enum.Side_BUY:
-        for order.LeavesQty.IntPart() > 0 && len(stock.asks) > 0 && stock.asks[0].price.Cmp(order.Price) <= 0 {
-            order.Process(stock.asks[0].price, stock.asks[0].size)
+    if msg.HasExecInst() {
+        if order.ExecInst, err = msg.GetExecInst(); err != nil {
+            return
+        }
+    }
 
-            stock.trade.price = order.LastPrice
-            stock.trade.size = order.LastShares
-            stock.trade.order = &order
+    if order.isPegged() && order.peg() == "" {
+        return quickfix.ValueIsIncorrect(tag.ExecInst)
+    }
 
-            stock.asks[0].size = stock.asks[0].size.Sub(order.LastShares)
-            if stock.asks[0].size.Cmp(decimal.Zero) == 0 {
-                if stock.asks[0].order != nil {
-                    stock.asks[0].order.Process(order.LastPrice, order.LastShares)
-                }
-                stock.asks = stock.asks[1:]
+    //a discretionary order shows at its price but trades up to DiscretionOffset past it
+    if msg.HasDiscretionOffset() {
+        if msg.HasDiscretionInst() {
+            var inst enum.DiscretionInst
+            if inst, err = msg.GetDiscretionInst(); err != nil {
+                return
             }
-        }
-    case enum.Side_SELL:
-        for order.LeavesQty.IntPart() > 0 && len(stock.bids) > 0 && stock.bids[0].price.Cmp(order.Price) >= 0 {
-            order.Process(stock.bids[0].price, stock.bids[0].size)
-            stock.bids[0].order.Process(order.LastPrice, order.LastShares)
-
-            stock.trade.price = order.LastPrice
-            stock.trade.size = order.LastShares
-            stock.trade.order = &order
-
-            stock.bids[0].size = stock.bids[0].size.Sub(order.LastShares)
-            if stock.bids[0].size.Cmp(decimal.Zero) == 0 {
-                if stock.asks[0].order != nil {
-                    stock.bids[0].order.Process(order.LastPrice, order.LastShares)
-                }
-                stock.bids = stock.bids[1:]
+            if inst != enum.DiscretionInst_RELATED_TO_DISPLAYED_PRICE {
+                return quickfix.ValueIsIncorrect(tag.DiscretionInst)
             }
         }
+        if order.DiscretionOffset, err = msg.GetDiscretionOffset(); err != nil {
+            return
+        }
+        if order.DiscretionOffset.Cmp(decimal.Zero) < 0 {
+            return quickfix.ValueIsIncorrect(tag.DiscretionOffset)
+        }
     }
 
-    if order.OrderStatus != enum.OrdStatus_FILLED {
-        bidAsk := BidAsk{order: &order, price: order.Price, size: order.LeavesQty}
+    //an order with a MinQty only trades if at least MinQty fills on arrival
+    if msg.HasMinQty() {
+        if order.MinQty, err = msg.GetMinQty(); err != nil {
+            return
+        }
+        if order.MinQty.Cmp(decimal.Zero) < 0 || order.MinQty.Cmp(order.OrderQty) > 0 {
+            return quickfix.ValueIsIncorrect(tag.MinQty)
+        }
+    }
 
-        switch order.Side {
-        case enum.Side_BUY:
-            e.quotes[order.Symbol].bids = append(e.quotes[order.Symbol].bids, bidAsk)
-            sort.Sort(BidList(e.quotes[order.Symbol].bids))
-        case enum.Side_SELL:
-            e.quotes[order.Symbol].asks = append(e.quotes[order.Symbol].asks, bidAsk)
-            sort.Sort(AskList(e.quotes[order.Symbol].asks))
+    //an iceberg shows MaxFloor of its quantity at a time
+    if msg.HasMaxFloor() {
+        if order.MaxFloor, err = msg.GetMaxFloor(); err != nil {
+            return
+        }
+        if order.MaxFloor.Cmp(decimal.Zero) < 0 {
+            return quickfix.ValueIsIncorrect(tag.MaxFloor)
         }
     }
 
-    execReport := fix42er.New(
-        field.NewOrderID(order.ClOrdID),
-        field.NewExecID(order.ExecID),
-        field.NewExecTransType(order.ExecTransType),
-        field.NewExecType(order.ExecType),
-        field.NewOrdStatus(order.OrderStatus),
-        field.NewSymbol(order.Symbol),
-        field.NewSide(order.Side),
-        field.NewLeavesQty(order.LeavesQty, 2),
-        field.NewCumQty(order.CumQty, 2),
-        field.NewAvgPx(order.AvgPx, 2),
-    )
+    if err = e.readTimeInForce(msg, &order); err != nil {
+        return
+    }
 
-    execReport.SetClOrdID(order.ClOrdID)
-    execReport.SetOrderQty(order.OrderQty, 2)
-    execReport.SetLastShares(order.LastShares, 2)
-    execReport.SetLastPx(order.LastPrice, 2)
+    order.SessionID = sessionID
+    order.LeavesQty = order.OrderQty
+    order.OrderStatus = enum.OrdStatus_NEW
+    order.TotalPrice = decimal.Zero
+    order.LastPrice = decimal.Zero
+    order.LastShares = decimal.Zero
+
+    if e.findOrder(sessionID, order.ClOrdID) != nil {
+        e.printf("[SERVER]: Duplicate ClOrdID %v from %v\n", order.ClOrdID, sessionID)
+
+        e.reject(&order, "Duplicate ClOrdID "+order.ClOrdID)
+        order.OrdRejReason = enum.OrdRejReason_DUPLICATE_ORDER
+        e.recordIDs()
+        e.send(newExecutionReport(&order), sessionID)
+        return
+    }
 
     e.orders = append(e.orders, &order)
+    e.index(&order)
 
-    if msg.HasAccount() {
-        acct, err := msg.GetAccount()
-        if err != nil {
-            return err
-        }
-        execReport.SetAccount(acct)
+    stock, quoteErr := e.getQuote(order.Symbol)
+    if quoteErr != nil {
+        e.printf("[SERVER]: Unknown symbol %v: %v\n", order.Symbol, quoteErr)
+
+        e.reject(&order, "Unknown symbol "+order.Symbol)
+        order.OrdRejReason = enum.OrdRejReason_UNKNOWN_SYMBOL
+        e.record(eventReject, &order)
+        e.send(newExecutionReport(&order), order.SessionID)
+        return
+    }
+
+    if text, reason := e.checkPhase(stock, &order); text != "" {
+        e.printf("[SERVER]: Order %v refused in %v: %v\n", order.ClOrdID, stock.phase, text)
+
+        e.reject(&order, text)
+        order.OrdRejReason = reason
+        e.record(eventReject, &order)
+        e.send(newExecutionReport(&order), order.SessionID)
+        return
+    }
+
+    //a pegged order is checked at the price it will rest at
+    if order.isPegged() {
+        order.PeggedPrice, _ = stock.pegPrice(&order)
+    }
+
+    if text := e.checkRisk(stock, &order, &order); text != "" {
+        e.printf("[SERVER]: Order %v breaches a risk limit: %v\n", order.ClOrdID, text)
+
+        e.reject(&order, text)
+        order.OrdRejReason = enum.OrdRejReason_ORDER_EXCEEDS_LIMIT
+        e.record(eventReject, &order)
+        e.send(newExecutionReport(&order), order.SessionID)
+        return
+    }
+
+    switch {
+    case order.isStop():
+        e.holdStop(&order)
+    case order.TimeInForce == enum.TimeInForce_AT_THE_CLOSE && stock.phase != closingCall:
+        e.holdForClose(&order)
+    case !e.canFill(stock, &order):
+        _, text := order.required()
+        e.reject(&order, text)
+        e.record(eventReject, &order)
+        e.send(newExecutionReport(&order), order.SessionID)
+    case order.isPegged() && order.PeggedPrice.Cmp(decimal.Zero) <= 0:
+        e.holdPeg(&order)
+    default:
+        e.acknowledge(&order)
+        e.execute(stock, &order)
     }
 
-    quickfix.SendToTarget(execReport, sessionID)
+    if order.isPegged() && order.isWorking() {
+        e.pegs[order.Symbol] = append(e.pegs[order.Symbol], &order)
+    }
+
+    e.triggerStops(stock)
+    e.repeg(stock)
 
     e.DumpOrders()
     return
 }
 
-func (e *executor) OnFIX42OrderStatusRequest(msg fix42osr.OrderStatusRequest, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
+//acknowledge tells the owner of order that it has been accepted, which is when it gets its OrderID
+func (e *executor) acknowledge(order *Order) {
+    if order.OrderID == "" {
+        order.OrderID = e.genOrderID().Value()
+        e.index(order)
+    }
 
-    clOrdID, _ := msg.GetClOrdID()
-    //symbol, _ := msg.GetSymbol()
-    //side, _ := msg.GetSide()
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_NEW
+    order.ExecID = e.genExecID().Value()
 
-    fmt.Printf("[SERVER]: OrderStatusRequest %v\n", clOrdID)
+    e.record(eventNew, order)
+    e.send(newExecutionReport(order), order.SessionID)
+}
 
-    var order *Order
-    for _, o := range e.orders {
-        if o.ClOrdID == clOrdID {
-            order = o
-            break
-        }
+//sendFill reports the last execution of order to the session that owns it
+func (e *executor) sendFill(order *Order) {
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_PARTIAL_FILL
+    if order.OrderStatus == enum.OrdStatus_FILLED {
+        order.ExecType = enum.ExecType_FILL
     }
+    order.ExecID = e.genExecID().Value()
 
-    fmt.Printf("Order: %v\n", order)
+    if order.isSynthetic() {
+        return
+    }
 
-    execReport := fix42er.New(
-        field.NewOrderID(order.ClOrdID),
-        field.NewExecID(order.ExecID),
-        field.NewExecTransType(order.ExecTransType),
-        field.NewExecType(order.ExecType),
-        field.NewOrdStatus(order.OrderStatus),
-        field.NewSymbol(order.Symbol),
-        field.NewSide(order.Side),
-        field.NewLeavesQty(order.LeavesQty, 2),
-        field.NewCumQty(order.CumQty, 2),
-        field.NewAvgPx(order.AvgPx, 2),
-    )
+    e.record(eventFill, order)
+    e.send(newExecutionReport(order), order.SessionID)
+}
 
-    execReport.SetClOrdID(order.ClOrdID)
-    execReport.SetOrderQty(order.OrderQty, 2)
-    execReport.SetLastShares(order.LastShares, 2)
-    execReport.SetLastPx(order.LastPrice, 2)
-    execReport.SetPrice(order.Price, 2)
+//execute runs an acknowledged order through the book, fills are reported as they happen
+func (e *executor) execute(stock *Quote, order *Order) {
+    //orders that cannot fill what they require on arrival do not touch the book
+    fills, err := stock.book.Submit(e.bookOrderOf(order))
+    if err == orderbook.ErrMinQuantity {
+        e.cancel(order)
+        _, order.Text = order.required()
+        e.send(newExecutionReport(order), order.SessionID)
+        return
+    }
+    e.report(stock, order, fills)
 
-    if msg.HasAccount() {
-        acct, err := msg.GetAccount()
-        if err != nil {
-            return err
-        }
-        execReport.SetAccount(acct)
+    //filled, or cancelled by self-trade prevention
+    if !order.isWorking() {
+        return
     }
 
-    quickfix.SendToTarget(execReport, sessionID)
+    //market and immediate orders never rest, except market orders waiting for an auction. Whatever the book
+    //did not keep is cancelled.
+    if _, rested := stock.book.Get(order.OrderID); !rested {
+        e.cancel(order)
+        e.send(newExecutionReport(order), order.SessionID)
+        return
+    }
 
-    e.DumpOrders()
-    return
+    e.resting[order.OrderID] = order
+    e.record(eventRest, order)
 }
 
 func main() {
+    replayLog := flag.String("replay", "", "replay the application messages of a FIX message log and diff the execution reports")
+    replayOut := flag.String("out", "", "file the execution reports of -replay are written to, stdout if not set")
+    replayPrices := flag.String("prices", "", "csv or json file of the reference prices recorded for -replay")
     flag.Parse()
 
     cfgFileName := path.Join("config", "acceptor.cfg")
@@ -440,22 +746,67 @@ func main() {
         cfgFileName = flag.Arg(0)
     }
 
-    cfg, err := os.Open(cfgFileName)
+    if *replayLog != "" {
+        os.Exit(replay(cfgFileName, *replayLog, *replayPrices, *replayOut))
+    }
+
+    appSettings, sections, err := readSettings(cfgFileName)
     if err != nil {
-        fmt.Printf("Error opening %v, %v\n", cfgFileName, err)
+        fmt.Printf("Error reading %v, %v\n", cfgFileName, err)
         return
     }
 
-    appSettings, err := quickfix.ParseSettings(cfg)
+    priceSource, err := newPriceSource(appSettings.GlobalSettings(), sections[pricesSection])
     if err != nil {
-        fmt.Println("Error reading cfg,", err)
+        fmt.Printf("Unable to create price source: %s\n", err)
         return
     }
 
     logFactory := quickfix.NewScreenLogFactory()
-    app := newExecutor()
+    app, err := newExecutor(appSettings.GlobalSettings(), priceSource)
+    if err != nil {
+        fmt.Printf("Unable to create executor: %s\n", err)
+        return
+    }
+
+    app.risk, err = newRiskRules(appSettings.GlobalSettings(), appSettings.SessionSettings(), sections[riskSection])
+    if err != nil {
+        fmt.Printf("Unable to read the risk limits: %s\n", err)
+        return
+    }
+
+    app.selfTrade, err = newSelfTradeRules(appSettings.GlobalSettings(), appSettings.SessionSettings())
+    if err != nil {
+        fmt.Printf("Unable to read the self-trade prevention: %s\n", err)
+        return
+    }
+
+    app.phases, err = newPhaseRules(appSettings.GlobalSettings(), sections[phasesSection], app.marketClose)
+    if err != nil {
+        fmt.Printf("Unable to read the trading phases: %s\n", err)
+        return
+    }
+
+    app.marketMaker, err = newMarketMakerRules(appSettings.GlobalSettings(), sections[marketMakerSection])
+    if err != nil {
+        fmt.Printf("Unable to read the market makers: %s\n", err)
+        return
+    }
+
+    if err = app.restore(appSettings.GlobalSettings()); err != nil {
+        fmt.Printf("Unable to restore the journal: %s\n", err)
+        return
+    }
+
+    go app.sweepExpiredOrders(time.Second)
+
+    //sequence numbers survive a restart only with a FileStorePath, and only if ResetOnLogon=N
+    storeFactory := quickfix.NewMemoryStoreFactory()
+    if appSettings.GlobalSettings().HasSetting(config.FileStorePath) {
+        storeFactory = quickfix.NewFileStoreFactory(appSettings)
+    }
 
-    acceptor, err := quickfix.NewAcceptor(app, quickfix.NewMemoryStoreFactory(), appSettings, logFactory)
+    acceptor, err := quickfix.NewAcceptor(app, storeFactory, appSettings, logFactory)
     if err != nil {
         fmt.Printf("Unable to create Acceptor: %s\n", err)
         return
@@ -467,7 +818,7 @@ func main() {
         return
     }
 
-    interrupt := make(chan os.Signal)
+    interrupt := make(chan os.Signal, 1)
     signal.Notify(interrupt, os.Interrupt, os.Kill)
     <-interrupt
 
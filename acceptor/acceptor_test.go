@@ -0,0 +1,2003 @@
+package main
+
+import (
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/quickfixgo/quickfix/field"
+    "github.com/quickfixgo/quickfix/fix42"
+    "github.com/quickfixgo/quickfix/tag"
+    "github.com/shopspring/decimal"
+
+    fix42md "github.com/quickfixgo/quickfix/fix42/marketdatasnapshotfullrefresh"
+    fix42mdr "github.com/quickfixgo/quickfix/fix42/marketdatarequest"
+    fix42nos "github.com/quickfixgo/quickfix/fix42/newordersingle"
+    fix42osr "github.com/quickfixgo/quickfix/fix42/orderstatusrequest"
+
+    "github.com/btasdoven/go-finance"
+
+    "github.com/btasdoven/quickfixwebclient/acceptor/orderbook"
+)
+
+//outbox collects what the executor sends instead of handing it to quickfix
+type outbox struct {
+    sync.Mutex
+    messages map[quickfix.SessionID][]*quickfix.Message
+}
+
+func (o *outbox) send(m quickfix.Messagable, sessionID quickfix.SessionID) error {
+    o.Lock()
+    defer o.Unlock()
+    o.messages[sessionID] = append(o.messages[sessionID], m.ToMessage())
+    return nil
+}
+
+//executionReports returns the execution reports sent to sessionID
+func (o *outbox) executionReports(sessionID quickfix.SessionID) (reports []*quickfix.Message) {
+    o.Lock()
+    defer o.Unlock()
+    for _, m := range o.messages[sessionID] {
+        if msgType, _ := m.Header.GetString(tag.MsgType); msgType == string(enum.MsgType_EXECUTION_REPORT) {
+            reports = append(reports, m)
+        }
+    }
+    return
+}
+
+//newTestExecutor builds an executor trading symbol TEST around 100 on an empty book
+func newTestExecutor(t *testing.T, settings *quickfix.SessionSettings) (*executor, *outbox) {
+    prices := staticSource{"TEST": {Last: decimal.New(100, 0), Bid: decimal.New(99, 0), Ask: decimal.New(101, 0)}}
+
+    e, err := newExecutor(settings, prices)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    out := &outbox{messages: make(map[quickfix.SessionID][]*quickfix.Message)}
+    e.send = out.send
+    return e, out
+}
+
+func testSession(i int) quickfix.SessionID {
+    return quickfix.SessionID{BeginString: enum.BeginStringFIX42, SenderCompID: "FIXIMULATOR", TargetCompID: fmt.Sprintf("CLIENT%v", i)}
+}
+
+func newLimitOrder(clOrdID string, side enum.Side, qty int64, price int64) *quickfix.Message {
+    order := fix42nos.New(
+        field.NewClOrdID(clOrdID),
+        field.NewHandlInst(enum.HandlInst_AUTOMATED_EXECUTION_ORDER_PRIVATE_NO_BROKER_INTERVENTION),
+        field.NewSymbol("TEST"),
+        field.NewSide(side),
+        field.NewTransactTime(time.Now()),
+        field.NewOrdType(enum.OrdType_LIMIT),
+    )
+    order.SetOrderQty(decimal.New(qty, 0), 0)
+    order.SetPrice(decimal.New(price, 0), 2)
+    order.SetTimeInForce(enum.TimeInForce_GOOD_TILL_CANCEL)
+    return order.ToMessage()
+}
+
+//trade sends orders alternating buy and sell limit orders from every session at once
+func trade(t *testing.T, e *executor, sessions int, orders int) {
+    var wg sync.WaitGroup
+    for s := 0; s < sessions; s++ {
+        wg.Add(1)
+        go func(s int) {
+            defer wg.Done()
+            sessionID := testSession(s)
+            for i := 0; i < orders; i++ {
+                side := enum.Side_BUY
+                if (s+i)%2 == 1 {
+                    side = enum.Side_SELL
+                }
+
+                if reject := e.FromApp(newLimitOrder(fmt.Sprintf("%v-%v", s, i), side, int64(1+i%7), int64(98+i%5)), sessionID); reject != nil {
+                    t.Errorf("order %v-%v rejected: %v", s, i, reject)
+                }
+            }
+        }(s)
+    }
+    wg.Wait()
+}
+
+func TestConcurrentNewOrderSingles(t *testing.T) {
+    const sessions, orders = 8, 50
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+
+    trade(t, e, sessions, orders)
+
+    if len(e.orders) != sessions*orders {
+        t.Fatalf("executor holds %v orders, expected %v", len(e.orders), sessions*orders)
+    }
+
+    execIDs := make(map[string]bool)
+    bought, sold := decimal.Zero, decimal.Zero
+
+    for s := 0; s < sessions; s++ {
+        reports := out.executionReports(testSession(s))
+        if len(reports) < orders {
+            t.Errorf("session %v got %v execution reports for %v orders", s, len(reports), orders)
+        }
+
+        for _, report := range reports {
+            execID, _ := report.Body.GetString(tag.ExecID)
+            if execIDs[execID] {
+                t.Errorf("ExecID %v reported twice", execID)
+            }
+            execIDs[execID] = true
+
+            var lastShares field.LastSharesField
+            report.Body.Get(&lastShares)
+            if side, _ := report.Body.GetString(tag.Side); enum.Side(side) == enum.Side_BUY {
+                bought = bought.Add(lastShares.Value())
+            } else {
+                sold = sold.Add(lastShares.Value())
+            }
+        }
+    }
+
+    //the book starts empty, so every share bought was sold by another client
+    if !bought.Equals(sold) {
+        t.Errorf("clients bought %v but sold %v", bought, sold)
+    }
+
+    for _, order := range e.orders {
+        if !order.CumQty.Add(order.LeavesQty).Equals(order.OrderQty) {
+            t.Errorf("order %v: CumQty %v and LeavesQty %v do not add up to OrderQty %v", order.ClOrdID, order.CumQty, order.LeavesQty, order.OrderQty)
+        }
+    }
+}
+
+func TestConcurrentSessionsWithMarketMakerAndExpiry(t *testing.T) {
+    settings := quickfix.NewSessionSettings()
+    settings.Set(MarketMaker, "Y")
+    settings.Set(MarketMakerInterval, "1ms")
+    settings.Set(MarketMakerVolatility, "1")
+
+    e, _ := newTestExecutor(t, settings)
+
+    done := make(chan bool)
+    go func() {
+        for {
+            select {
+            case <-done:
+                return
+            case now := <-time.After(time.Millisecond):
+                e.expireOrders(now)
+            }
+        }
+    }()
+
+    trade(t, e, 4, 50)
+    close(done)
+
+    e.lock.Lock()
+    defer e.lock.Unlock()
+
+    for _, order := range e.orders {
+        if !order.CumQty.Add(order.LeavesQty).Equals(order.OrderQty) {
+            t.Errorf("order %v: CumQty %v and LeavesQty %v do not add up to OrderQty %v", order.ClOrdID, order.CumQty, order.LeavesQty, order.OrderQty)
+        }
+    }
+}
+
+func newCancelRequest(origClOrdID string, clOrdID string, side enum.Side) *quickfix.Message {
+    msg := quickfix.NewMessage()
+    header := fix42.NewHeader(&msg.Header)
+    header.SetMsgType(enum.MsgType_ORDER_CANCEL_REQUEST)
+
+    msg.Body.Set(field.NewOrigClOrdID(origClOrdID))
+    msg.Body.Set(field.NewClOrdID(clOrdID))
+    msg.Body.Set(field.NewSymbol("TEST"))
+    msg.Body.Set(field.NewSide(side))
+    msg.Body.Set(field.NewTransactTime(time.Now()))
+    return msg
+}
+
+//last returns the last message sent to sessionID
+func (o *outbox) last(sessionID quickfix.SessionID) *quickfix.Message {
+    o.Lock()
+    defer o.Unlock()
+    messages := o.messages[sessionID]
+    if len(messages) == 0 {
+        return nil
+    }
+    return messages[len(messages)-1]
+}
+
+func newStopOrder(clOrdID string, side enum.Side, qty int64, stopPx int64, price int64) *quickfix.Message {
+    ordType := enum.OrdType_STOP
+    if price > 0 {
+        ordType = enum.OrdType_STOP_LIMIT
+    }
+
+    order := fix42nos.New(
+        field.NewClOrdID(clOrdID),
+        field.NewHandlInst(enum.HandlInst_AUTOMATED_EXECUTION_ORDER_PRIVATE_NO_BROKER_INTERVENTION),
+        field.NewSymbol("TEST"),
+        field.NewSide(side),
+        field.NewTransactTime(time.Now()),
+        field.NewOrdType(ordType),
+    )
+    order.SetOrderQty(decimal.New(qty, 0), 0)
+    order.SetStopPx(decimal.New(stopPx, 0), 2)
+    if price > 0 {
+        order.SetPrice(decimal.New(price, 0), 2)
+    }
+    order.SetTimeInForce(enum.TimeInForce_GOOD_TILL_CANCEL)
+    return order.ToMessage()
+}
+
+func TestStopOrders(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    client, maker, taker := testSession(1), testSession(2), testSession(3)
+
+    //report is an execution report written as strings
+    type report struct {
+        execType enum.ExecType
+        lastPx   string
+        cumQty   string
+    }
+    reports := func(clOrdID string) (r []report) {
+        for _, msg := range out.executionReports(client) {
+            if id, _ := msg.Body.GetString(tag.ClOrdID); id != clOrdID {
+                continue
+            }
+            var execType field.ExecTypeField
+            var lastPx field.LastPxField
+            var cumQty field.CumQtyField
+            msg.Body.Get(&execType)
+            msg.Body.Get(&lastPx)
+            msg.Body.Get(&cumQty)
+            r = append(r, report{execType.Value(), lastPx.Value().String(), cumQty.Value().String()})
+        }
+        return
+    }
+    expect := func(step string, clOrdID string, ordType enum.OrdType, booked bool, expected ...report) {
+        if actual := reports(clOrdID); !reflect.DeepEqual(actual, expected) {
+            t.Errorf("%v: %v reports %v, expected %v", step, clOrdID, actual, expected)
+        }
+        order := e.findOrder(client, clOrdID)
+        if order.OrdType != ordType {
+            t.Errorf("%v: %v is a %v order, expected %v", step, clOrdID, order.OrdType, ordType)
+        }
+        if _, ok := e.quotes["TEST"].book.Get(order.OrderID); ok != booked {
+            t.Errorf("%v: %v in the book %v, expected %v", step, clOrdID, ok, booked)
+        }
+    }
+
+    //stops wait outside the book, the last trade at 100 elects none of them
+    e.FromApp(newStopOrder("S1", enum.Side_BUY, 5, 102, 0), client)
+    e.FromApp(newStopOrder("S2", enum.Side_SELL, 5, 98, 97), client)
+    e.FromApp(newStopOrder("S3", enum.Side_SELL, 4, 99, 100), client)
+    expect("held", "S1", enum.OrdType_STOP, false, report{enum.ExecType_NEW, "0", "0"})
+    expect("held", "S2", enum.OrdType_STOP_LIMIT, false, report{enum.ExecType_NEW, "0", "0"})
+    expect("held", "S3", enum.OrdType_STOP_LIMIT, false, report{enum.ExecType_NEW, "0", "0"})
+
+    //a trade at the StopPx of a buy stop elects it, it buys at the market
+    e.FromApp(newLimitOrder("A", enum.Side_SELL, 10, 102), maker)
+    e.FromApp(newLimitOrder("T1", enum.Side_BUY, 3, 102), taker)
+    expect("bought through", "S1", enum.OrdType_MARKET, false, report{enum.ExecType_NEW, "0", "0"}, report{enum.ExecType_RESTATED, "0", "0"}, report{enum.ExecType_FILL, "102", "5"})
+    expect("bought through", "S2", enum.OrdType_STOP_LIMIT, false, report{enum.ExecType_NEW, "0", "0"})
+
+    //a trade at or below the StopPx of sell stops elects them in arrival order as limit orders, one that
+    //cannot trade at its limit rests
+    e.FromApp(newLimitOrder("B", enum.Side_BUY, 10, 98), maker)
+    e.FromApp(newLimitOrder("T2", enum.Side_SELL, 2, 98), taker)
+    expect("sold through", "S2", enum.OrdType_LIMIT, false, report{enum.ExecType_NEW, "0", "0"}, report{enum.ExecType_RESTATED, "0", "0"}, report{enum.ExecType_FILL, "98", "5"})
+    expect("sold through", "S3", enum.OrdType_LIMIT, true, report{enum.ExecType_NEW, "0", "0"}, report{enum.ExecType_RESTATED, "0", "0"})
+
+    if resting, _ := e.quotes["TEST"].book.Get(e.findOrder(client, "S3").OrderID); !resting.Price.Equals(decimal.New(100, 0)) {
+        t.Errorf("S3 rests at %v, expected its limit of 100", resting.Price)
+    }
+
+    //the owner of a triggered stop still replaces it as the stop it sent
+    replace := newReplaceRequest("S3", "S4", enum.Side_SELL, 3, 101)
+    replace.Body.Set(field.NewOrdType(enum.OrdType_STOP_LIMIT))
+    replace.Body.Set(field.NewStopPx(decimal.New(99, 0), 2))
+    e.FromApp(replace, client)
+    expect("triggered stop replaced", "S4", enum.OrdType_LIMIT, true, report{enum.ExecType_REPLACED, "0", "0"})
+
+    if resting, _ := e.quotes["TEST"].book.Get(e.findOrder(client, "S4").OrderID); !resting.Price.Equals(decimal.New(101, 0)) || !resting.Quantity.Equals(decimal.New(3, 0)) {
+        t.Errorf("S4 rests %v at %v, expected 3 at 101", resting.Quantity, resting.Price)
+    }
+}
+
+func TestTimeInForce(t *testing.T) {
+    settings := quickfix.NewSessionSettings()
+    settings.Set(MarketCloseTime, "16:00:00")
+
+    e, out := newTestExecutor(t, settings)
+    clock := time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC)
+    e.useClock(func() time.Time { return clock })
+    client, maker := testSession(1), testSession(2)
+
+    with := func(msg *quickfix.Message, fields ...quickfix.FieldWriter) *quickfix.Message {
+        for _, f := range fields {
+            msg.Body.Set(f)
+        }
+        return msg
+    }
+    execTypes := func(clOrdID string) (execTypes []enum.ExecType) {
+        for _, msg := range out.executionReports(client) {
+            if id, _ := msg.Body.GetString(tag.ClOrdID); id == clOrdID {
+                var execType field.ExecTypeField
+                msg.Body.Get(&execType)
+                execTypes = append(execTypes, execType.Value())
+            }
+        }
+        return
+    }
+    expect := func(step string, clOrdID string, expected ...enum.ExecType) {
+        if actual := execTypes(clOrdID); !reflect.DeepEqual(actual, expected) {
+            t.Errorf("%v: %v reports %v, expected %v", step, clOrdID, actual, expected)
+        }
+    }
+
+    //IMMEDIATE_OR_CANCEL fills what it can and cancels the rest
+    e.FromApp(newLimitOrder("M1", enum.Side_SELL, 10, 101), maker)
+    e.FromApp(with(newLimitOrder("I", enum.Side_BUY, 15, 101), field.NewTimeInForce(enum.TimeInForce_IMMEDIATE_OR_CANCEL)), client)
+    expect("immediate or cancel", "I", enum.ExecType_NEW, enum.ExecType_PARTIAL_FILL, enum.ExecType_CANCELED)
+    var cumQty field.CumQtyField
+    out.last(client).Body.Get(&cumQty)
+    if !cumQty.Value().Equals(decimal.New(10, 0)) {
+        t.Errorf("immediate or cancel: CumQty %v, expected 10", cumQty.Value())
+    }
+
+    //FILL_OR_KILL larger than the book is rejected and leaves it as it was
+    e.FromApp(newLimitOrder("M2", enum.Side_SELL, 10, 102), maker)
+    e.FromApp(with(newLimitOrder("F", enum.Side_BUY, 20, 102), field.NewTimeInForce(enum.TimeInForce_FILL_OR_KILL)), client)
+    expect("fill or kill", "F", enum.ExecType_REJECTED)
+    if asks := e.quotes["TEST"].book.Depth(orderbook.Sell, 1); len(asks) != 1 || !asks[0].Price.Equals(decimal.New(102, 0)) || !asks[0].Quantity.Equals(decimal.New(10, 0)) {
+        t.Errorf("fill or kill: asks %+v, expected 10 at 102", asks)
+    }
+
+    //DAY orders expire at MarketCloseTime, GTD ones at their ExpireTime or at the close of their ExpireDate
+    e.FromApp(with(newLimitOrder("D", enum.Side_BUY, 5, 90), field.NewTimeInForce(enum.TimeInForce_DAY)), client)
+    e.FromApp(with(newLimitOrder("G1", enum.Side_BUY, 5, 90), field.NewTimeInForce(enum.TimeInForce_GOOD_TILL_DATE), field.NewExpireTime(clock.Add(2*time.Hour))), client)
+    e.FromApp(with(newLimitOrder("G2", enum.Side_BUY, 5, 90), field.NewTimeInForce(enum.TimeInForce_GOOD_TILL_DATE), field.NewExpireDate("20260303")), client)
+    e.FromApp(newLimitOrder("C", enum.Side_BUY, 5, 90), client)
+
+    steps := []struct {
+        now     time.Time
+        expired []string
+    }{
+        {time.Date(2026, 3, 2, 11, 59, 59, 0, time.UTC), nil},
+        {time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC), []string{"G1"}},
+        {time.Date(2026, 3, 2, 15, 59, 59, 0, time.UTC), []string{"G1"}},
+        {time.Date(2026, 3, 2, 16, 0, 0, 0, time.UTC), []string{"G1", "D"}},
+        {time.Date(2026, 3, 3, 15, 59, 59, 0, time.UTC), []string{"G1", "D"}},
+        {time.Date(2026, 3, 3, 16, 0, 0, 0, time.UTC), []string{"G1", "D", "G2"}},
+    }
+
+    for _, step := range steps {
+        clock = step.now
+        e.expireOrders(step.now)
+
+        expired := make(map[string]bool)
+        for _, clOrdID := range step.expired {
+            expired[clOrdID] = true
+        }
+        for _, clOrdID := range []string{"D", "G1", "G2", "C"} {
+            expected := []enum.ExecType{enum.ExecType_NEW}
+            if expired[clOrdID] {
+                expected = append(expected, enum.ExecType_EXPIRED)
+            }
+            expect(step.now.String(), clOrdID, expected...)
+
+            order := e.findOrder(client, clOrdID)
+            if _, booked := e.quotes["TEST"].book.Get(order.OrderID); booked == expired[clOrdID] {
+                t.Errorf("%v: %v in the book %v", step.now, clOrdID, booked)
+            }
+        }
+    }
+}
+
+func TestSessionIsolation(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    alice, bob := testSession(1), testSession(2)
+
+    tests := []struct {
+        name      string
+        sessionID quickfix.SessionID
+        msg       *quickfix.Message
+        msgType   enum.MsgType
+        status    enum.OrdStatus
+        tag       quickfix.Tag
+        value     string
+    }{
+        {"first order", alice, newLimitOrder("1", enum.Side_BUY, 10, 90), enum.MsgType_EXECUTION_REPORT, enum.OrdStatus_NEW, tag.ClOrdID, "1"},
+        {"same ClOrdID in another session", bob, newLimitOrder("1", enum.Side_BUY, 10, 90), enum.MsgType_EXECUTION_REPORT, enum.OrdStatus_NEW, tag.ClOrdID, "1"},
+        {"duplicate ClOrdID", alice, newLimitOrder("1", enum.Side_SELL, 5, 110), enum.MsgType_EXECUTION_REPORT, enum.OrdStatus_REJECTED, tag.OrdRejReason, string(enum.OrdRejReason_DUPLICATE_ORDER)},
+        {"status of another session's order", bob, fix42osr.New(field.NewClOrdID("2"), field.NewSymbol("TEST"), field.NewSide(enum.Side_BUY)).ToMessage(), enum.MsgType_EXECUTION_REPORT, enum.OrdStatus_REJECTED, tag.OrdRejReason, string(enum.OrdRejReason_UNKNOWN_ORDER)},
+        {"cancel of another session's order", bob, newCancelRequest("2", "3", enum.Side_BUY), enum.MsgType_ORDER_CANCEL_REJECT, enum.OrdStatus_REJECTED, tag.CxlRejReason, string(enum.CxlRejReason_UNKNOWN_ORDER)},
+        {"cancel reusing a ClOrdID", alice, newCancelRequest("1", "1", enum.Side_BUY), enum.MsgType_ORDER_CANCEL_REJECT, enum.OrdStatus_NEW, tag.CxlRejReason, string(enum.CxlRejReason_DUPLICATE_CLORDID)},
+        {"cancel of an own order", alice, newCancelRequest("1", "4", enum.Side_BUY), enum.MsgType_EXECUTION_REPORT, enum.OrdStatus_CANCELED, tag.OrigClOrdID, "1"},
+        {"the other session's order is untouched", bob, fix42osr.New(field.NewClOrdID("1"), field.NewSymbol("TEST"), field.NewSide(enum.Side_BUY)).ToMessage(), enum.MsgType_EXECUTION_REPORT, enum.OrdStatus_NEW, tag.ClOrdID, "1"},
+    }
+
+    e.FromApp(newLimitOrder("2", enum.Side_BUY, 10, 90), alice)
+
+    for _, test := range tests {
+        if reject := e.FromApp(test.msg, test.sessionID); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        reply := out.last(test.sessionID)
+        if msgType, _ := reply.Header.GetString(tag.MsgType); msgType != string(test.msgType) {
+            t.Errorf("%v: replied with MsgType %v, expected %v", test.name, msgType, test.msgType)
+            continue
+        }
+        if status, _ := reply.Body.GetString(tag.OrdStatus); status != string(test.status) {
+            t.Errorf("%v: OrdStatus %v, expected %v", test.name, status, test.status)
+        }
+        if value, _ := reply.Body.GetString(test.tag); value != test.value {
+            t.Errorf("%v: tag %v is %v, expected %v", test.name, test.tag, value, test.value)
+        }
+    }
+}
+
+func TestExecutionReportStates(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    buyer, seller := testSession(1), testSession(2)
+
+    e.FromApp(newLimitOrder("B", enum.Side_BUY, 10, 100), buyer)
+    e.FromApp(newLimitOrder("S1", enum.Side_SELL, 4, 100), seller)
+    e.FromApp(newLimitOrder("S2", enum.Side_SELL, 6, 99), seller)
+    e.FromApp(fix42osr.New(field.NewClOrdID("B"), field.NewSymbol("TEST"), field.NewSide(enum.Side_BUY)).ToMessage(), buyer)
+    e.FromApp(newLimitOrder("S3", enum.Side_SELL, 5, 101), seller)
+    e.FromApp(newCancelRequest("S3", "S4", enum.Side_SELL), seller)
+
+    expected := []struct {
+        sessionID     quickfix.SessionID
+        clOrdID       string
+        execTransType enum.ExecTransType
+        execType      enum.ExecType
+        ordStatus     enum.OrdStatus
+    }{
+        {buyer, "B", enum.ExecTransType_NEW, enum.ExecType_NEW, enum.OrdStatus_NEW},
+        {buyer, "B", enum.ExecTransType_NEW, enum.ExecType_PARTIAL_FILL, enum.OrdStatus_PARTIALLY_FILLED},
+        {buyer, "B", enum.ExecTransType_NEW, enum.ExecType_FILL, enum.OrdStatus_FILLED},
+        {buyer, "B", enum.ExecTransType_STATUS, enum.ExecType_FILL, enum.OrdStatus_FILLED},
+        {seller, "S1", enum.ExecTransType_NEW, enum.ExecType_NEW, enum.OrdStatus_NEW},
+        {seller, "S1", enum.ExecTransType_NEW, enum.ExecType_FILL, enum.OrdStatus_FILLED},
+        {seller, "S2", enum.ExecTransType_NEW, enum.ExecType_NEW, enum.OrdStatus_NEW},
+        {seller, "S2", enum.ExecTransType_NEW, enum.ExecType_FILL, enum.OrdStatus_FILLED},
+        {seller, "S3", enum.ExecTransType_NEW, enum.ExecType_NEW, enum.OrdStatus_NEW},
+        {seller, "S4", enum.ExecTransType_NEW, enum.ExecType_CANCELED, enum.OrdStatus_CANCELED},
+    }
+
+    reports := map[quickfix.SessionID][]*quickfix.Message{buyer: out.executionReports(buyer), seller: out.executionReports(seller)}
+    if len(reports[buyer])+len(reports[seller]) != len(expected) {
+        t.Fatalf("got %v and %v execution reports, expected %v", len(reports[buyer]), len(reports[seller]), len(expected))
+    }
+
+    execIDs := make(map[string]bool)
+    orderIDs := make(map[string]string)
+
+    for _, exp := range expected {
+        report := reports[exp.sessionID][0]
+        reports[exp.sessionID] = reports[exp.sessionID][1:]
+
+        clOrdID, _ := report.Body.GetString(tag.ClOrdID)
+        execTransType, _ := report.Body.GetString(tag.ExecTransType)
+        execType, _ := report.Body.GetString(tag.ExecType)
+        ordStatus, _ := report.Body.GetString(tag.OrdStatus)
+        if clOrdID != exp.clOrdID || execTransType != string(exp.execTransType) || execType != string(exp.execType) || ordStatus != string(exp.ordStatus) {
+            t.Errorf("report %v/%v/%v/%v, expected %+v", clOrdID, execTransType, execType, ordStatus, exp)
+        }
+
+        execID, _ := report.Body.GetString(tag.ExecID)
+        if execIDs[execID] {
+            t.Errorf("%v: ExecID %v reused", clOrdID, execID)
+        }
+        execIDs[execID] = true
+
+        //a cancel changes the ClOrdID but not the OrderID
+        orderID, _ := report.Body.GetString(tag.OrderID)
+        if origClOrdID, err := report.Body.GetString(tag.OrigClOrdID); err == nil {
+            clOrdID = origClOrdID
+        }
+        if known, ok := orderIDs[clOrdID]; ok && known != orderID {
+            t.Errorf("%v: OrderID changed from %v to %v", clOrdID, known, orderID)
+        }
+        orderIDs[clOrdID] = orderID
+    }
+
+    seen := make(map[string]bool)
+    for clOrdID, orderID := range orderIDs {
+        if orderID == clOrdID || seen[orderID] {
+            t.Errorf("%v: OrderID %v is not exchange assigned", clOrdID, orderID)
+        }
+        seen[orderID] = true
+    }
+
+    //orders refused before they are accepted have no OrderID, and take none away from the orders after them
+    e.FromApp(newLimitOrder("S1", enum.Side_SELL, 1, 100), seller)
+    if orderID, _ := out.last(seller).Body.GetString(tag.OrderID); orderID != "NONE" {
+        t.Errorf("duplicate ClOrdID rejected with OrderID %v, expected NONE", orderID)
+    }
+
+    e.FromApp(newLimitOrder("S5", enum.Side_SELL, 1, 101), seller)
+    last, _ := strconv.Atoi(orderIDs["S3"])
+    if orderID, _ := out.last(seller).Body.GetString(tag.OrderID); orderID != strconv.Itoa(last+1) {
+        t.Errorf("S5: OrderID %v, expected %v", orderID, last+1)
+    }
+}
+
+func newMarketOrder(clOrdID string, side enum.Side, qty int64) *quickfix.Message {
+    order := fix42nos.New(
+        field.NewClOrdID(clOrdID),
+        field.NewHandlInst(enum.HandlInst_AUTOMATED_EXECUTION_ORDER_PRIVATE_NO_BROKER_INTERVENTION),
+        field.NewSymbol("TEST"),
+        field.NewSide(side),
+        field.NewTransactTime(time.Now()),
+        field.NewOrdType(enum.OrdType_MARKET),
+    )
+    order.SetOrderQty(decimal.New(qty, 0), 0)
+    return order.ToMessage()
+}
+
+func TestMarketOrders(t *testing.T) {
+    //report is an execution report written as strings
+    type report struct {
+        execType   enum.ExecType
+        ordStatus  enum.OrdStatus
+        lastPx     string
+        lastShares string
+        cumQty     string
+        leavesQty  string
+        avgPx      string
+    }
+
+    //the book of TEST is seeded with 12 at 105, the asks are added in front of it
+    tests := []struct {
+        name    string
+        asks    [][2]int64
+        qty     int64
+        reports []report
+    }{
+        {"sweeps every level it needs", [][2]int64{{5, 101}, {5, 102}}, 20, []report{
+            {enum.ExecType_NEW, enum.OrdStatus_NEW, "0.00", "0.00", "0.00", "20.00", "0.00"},
+            {enum.ExecType_PARTIAL_FILL, enum.OrdStatus_PARTIALLY_FILLED, "101.00", "5.00", "5.00", "15.00", "101.00"},
+            {enum.ExecType_PARTIAL_FILL, enum.OrdStatus_PARTIALLY_FILLED, "102.00", "5.00", "10.00", "10.00", "101.50"},
+            {enum.ExecType_FILL, enum.OrdStatus_FILLED, "105.00", "10.00", "20.00", "0.00", "103.25"},
+        }},
+        {"cancels what the book cannot fill", [][2]int64{{5, 101}, {5, 102}}, 30, []report{
+            {enum.ExecType_NEW, enum.OrdStatus_NEW, "0.00", "0.00", "0.00", "30.00", "0.00"},
+            {enum.ExecType_PARTIAL_FILL, enum.OrdStatus_PARTIALLY_FILLED, "101.00", "5.00", "5.00", "25.00", "101.00"},
+            {enum.ExecType_PARTIAL_FILL, enum.OrdStatus_PARTIALLY_FILLED, "102.00", "5.00", "10.00", "20.00", "101.50"},
+            {enum.ExecType_PARTIAL_FILL, enum.OrdStatus_PARTIALLY_FILLED, "105.00", "12.00", "22.00", "8.00", "103.41"},
+            {enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, "0.00", "0.00", "22.00", "0.00", "103.41"},
+        }},
+    }
+
+    for _, test := range tests {
+        e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+        buyer, seller := testSession(1), testSession(2)
+
+        for i, ask := range test.asks {
+            e.FromApp(newLimitOrder(fmt.Sprintf("S%v", i), enum.Side_SELL, ask[0], ask[1]), seller)
+        }
+        if reject := e.FromApp(newMarketOrder("M", enum.Side_BUY, test.qty), buyer); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        var reports []report
+        for _, msg := range out.executionReports(buyer) {
+            var r report
+            get := func(tg quickfix.Tag) string {
+                value, _ := msg.Body.GetString(tg)
+                return value
+            }
+            r.execType, r.ordStatus = enum.ExecType(get(tag.ExecType)), enum.OrdStatus(get(tag.OrdStatus))
+            r.lastPx, r.lastShares = get(tag.LastPx), get(tag.LastShares)
+            r.cumQty, r.leavesQty, r.avgPx = get(tag.CumQty), get(tag.LeavesQty), get(tag.AvgPx)
+            reports = append(reports, r)
+        }
+        if !reflect.DeepEqual(reports, test.reports) {
+            t.Errorf("%v: reports\n%v\nexpected\n%v", test.name, reports, test.reports)
+        }
+
+        if _, rested := e.quotes["TEST"].book.Get(e.findOrder(buyer, "M").OrderID); rested {
+            t.Errorf("%v: market order rests in the book", test.name)
+        }
+    }
+}
+
+func newStatusRequest(orderID string, clOrdID string, symbol string, side enum.Side) *quickfix.Message {
+    request := fix42osr.New(field.NewClOrdID(clOrdID), field.NewSymbol(symbol), field.NewSide(side))
+    if orderID != "" {
+        request.SetOrderID(orderID)
+    }
+    return request.ToMessage()
+}
+
+func TestOrderStatusRequest(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    owner, other := testSession(1), testSession(2)
+
+    e.FromApp(newLimitOrder("A", enum.Side_BUY, 10, 90), owner)
+    orderID, _ := out.last(owner).Body.GetString(tag.OrderID)
+
+    tests := []struct {
+        name      string
+        sessionID quickfix.SessionID
+        request   *quickfix.Message
+        status    enum.OrdStatus
+    }{
+        {"by ClOrdID", owner, newStatusRequest("", "A", "TEST", enum.Side_BUY), enum.OrdStatus_NEW},
+        {"by OrderID", owner, newStatusRequest(orderID, "whatever", "TEST", enum.Side_BUY), enum.OrdStatus_NEW},
+        {"unknown ClOrdID", owner, newStatusRequest("", "B", "TEST", enum.Side_BUY), enum.OrdStatus_REJECTED},
+        {"unknown OrderID", owner, newStatusRequest("999", "A", "TEST", enum.Side_BUY), enum.OrdStatus_REJECTED},
+        {"wrong symbol", owner, newStatusRequest("", "A", "OTHER", enum.Side_BUY), enum.OrdStatus_REJECTED},
+        {"wrong side", owner, newStatusRequest("", "A", "TEST", enum.Side_SELL), enum.OrdStatus_REJECTED},
+        {"OrderID of another session", other, newStatusRequest(orderID, "A", "TEST", enum.Side_BUY), enum.OrdStatus_REJECTED},
+    }
+
+    for _, test := range tests {
+        if reject := e.FromApp(test.request, test.sessionID); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        reply := out.last(test.sessionID)
+        status, _ := reply.Body.GetString(tag.OrdStatus)
+        execTransType, _ := reply.Body.GetString(tag.ExecTransType)
+        if status != string(test.status) || execTransType != string(enum.ExecTransType_STATUS) {
+            t.Errorf("%v: OrdStatus %v ExecTransType %v, expected %v %v", test.name, status, execTransType, test.status, enum.ExecTransType_STATUS)
+        }
+
+        reason, _ := reply.Body.GetString(tag.OrdRejReason)
+        if test.status == enum.OrdStatus_REJECTED && reason != string(enum.OrdRejReason_UNKNOWN_ORDER) {
+            t.Errorf("%v: OrdRejReason %v, expected %v", test.name, reason, enum.OrdRejReason_UNKNOWN_ORDER)
+        }
+    }
+}
+
+func newReplaceRequest(origClOrdID string, clOrdID string, side enum.Side, qty int64, price int64) *quickfix.Message {
+    msg := newCancelRequest(origClOrdID, clOrdID, side)
+    msg.Header.SetField(tag.MsgType, field.NewMsgType(enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST))
+
+    msg.Body.Set(field.NewOrdType(enum.OrdType_LIMIT))
+    msg.Body.Set(field.NewOrderQty(decimal.New(qty, 0), 0))
+    msg.Body.Set(field.NewPrice(decimal.New(price, 0), 2))
+    return msg
+}
+
+func TestOrderCancelReplaceRequest(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    owner, other := testSession(1), testSession(2)
+
+    e.FromApp(newLimitOrder("A", enum.Side_BUY, 10, 90), owner)
+    e.FromApp(newLimitOrder("B", enum.Side_BUY, 10, 90), other)
+    e.FromApp(newLimitOrder("C", enum.Side_BUY, 10, 95), owner)
+    e.FromApp(newLimitOrder("S", enum.Side_SELL, 4, 95), other)
+    e.FromApp(newLimitOrder("D", enum.Side_SELL, 5, 100), other)
+
+    with := func(msg *quickfix.Message, fields ...quickfix.FieldWriter) *quickfix.Message {
+        for _, f := range fields {
+            msg.Body.Set(f)
+        }
+        return msg
+    }
+    //ahead reports whether A, under whatever ClOrdID, comes before B in the queue at 90
+    a, b := e.findOrder(owner, "A").OrderID, e.findOrder(other, "B").OrderID
+    ahead := func() bool {
+        for _, o := range e.quotes["TEST"].book.Orders(orderbook.Buy) {
+            if o.ID == a || o.ID == b {
+                return o.ID == a
+            }
+        }
+        return false
+    }
+
+    tests := []struct {
+        name    string
+        msg     *quickfix.Message
+        replies []map[quickfix.Tag]string
+        ahead   bool
+    }{
+        {"size decrease", newReplaceRequest("A", "A2", enum.Side_BUY, 6, 90), []map[quickfix.Tag]string{
+            {tag.MsgType: "8", tag.ExecType: "5", tag.OrdStatus: "0", tag.ClOrdID: "A2", tag.OrigClOrdID: "A", tag.OrderQty: "6.00", tag.LeavesQty: "6.00"},
+        }, true},
+        {"OrderQty not above CumQty", newReplaceRequest("C", "C2", enum.Side_BUY, 4, 95), []map[quickfix.Tag]string{
+            {tag.MsgType: "9", tag.ClOrdID: "C2", tag.OrigClOrdID: "C", tag.OrdStatus: "1", tag.CxlRejResponseTo: "2"},
+        }, true},
+        {"OrdType changed", with(newReplaceRequest("C", "C2", enum.Side_BUY, 10, 95), field.NewOrdType(enum.OrdType_MARKET)), []map[quickfix.Tag]string{
+            {tag.MsgType: "9", tag.ClOrdID: "C2", tag.OrigClOrdID: "C", tag.OrdStatus: "1", tag.CxlRejResponseTo: "2"},
+        }, true},
+        {"Side changed", newReplaceRequest("C", "C2", enum.Side_SELL, 10, 95), []map[quickfix.Tag]string{
+            {tag.MsgType: "9", tag.ClOrdID: "C2", tag.OrigClOrdID: "C", tag.OrdStatus: "1", tag.CxlRejResponseTo: "2"},
+        }, true},
+        {"unknown order", newReplaceRequest("X", "X2", enum.Side_BUY, 10, 95), []map[quickfix.Tag]string{
+            {tag.MsgType: "9", tag.ClOrdID: "X2", tag.OrigClOrdID: "X", tag.CxlRejResponseTo: "2", tag.CxlRejReason: "1"},
+        }, true},
+        {"crossing price", newReplaceRequest("C", "C2", enum.Side_BUY, 10, 100), []map[quickfix.Tag]string{
+            {tag.MsgType: "8", tag.ExecType: "5", tag.OrdStatus: "1", tag.ClOrdID: "C2", tag.OrigClOrdID: "C", tag.CumQty: "4.00", tag.LeavesQty: "6.00"},
+            {tag.MsgType: "8", tag.ExecType: "1", tag.OrdStatus: "1", tag.ClOrdID: "C2", tag.LastPx: "100.00", tag.LastShares: "5.00", tag.CumQty: "9.00", tag.LeavesQty: "1.00"},
+        }, true},
+        {"size increase", newReplaceRequest("A2", "A3", enum.Side_BUY, 8, 90), []map[quickfix.Tag]string{
+            {tag.MsgType: "8", tag.ExecType: "5", tag.OrdStatus: "0", tag.ClOrdID: "A3", tag.OrigClOrdID: "A2", tag.LeavesQty: "8.00"},
+        }, false},
+    }
+
+    for _, test := range tests {
+        sent := len(out.messages[owner])
+        if reject := e.FromApp(test.msg, owner); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        replies := out.messages[owner][sent:]
+        if len(replies) != len(test.replies) {
+            t.Errorf("%v: %v replies, expected %v", test.name, len(replies), len(test.replies))
+            continue
+        }
+        for i, expected := range test.replies {
+            for tg, value := range expected {
+                actual, _ := replies[i].Body.GetString(tg)
+                if tg == tag.MsgType {
+                    actual, _ = replies[i].Header.GetString(tg)
+                }
+                if actual != value {
+                    t.Errorf("%v: reply %v tag %v is %v, expected %v", test.name, i, tg, actual, value)
+                }
+            }
+        }
+
+        if ahead() != test.ahead {
+            t.Errorf("%v: A ahead of B %v, expected %v", test.name, !test.ahead, test.ahead)
+        }
+    }
+}
+
+//lastOf returns the last execution report sent to sessionID about clOrdID
+func (o *outbox) lastOf(sessionID quickfix.SessionID, clOrdID string) (last *quickfix.Message) {
+    for _, report := range o.executionReports(sessionID) {
+        if id, _ := report.Body.GetString(tag.ClOrdID); id == clOrdID {
+            last = report
+        }
+    }
+    return
+}
+
+//sameBook reports the first difference between the books of TEST of two executors
+func sameBook(a *executor, b *executor) error {
+    for _, side := range []orderbook.Side{orderbook.Buy, orderbook.Sell} {
+        ordersA, ordersB := a.quotes["TEST"].book.Orders(side), b.quotes["TEST"].book.Orders(side)
+        if len(ordersA) != len(ordersB) {
+            return fmt.Errorf("side %v holds %v orders, expected %v", side, len(ordersB), len(ordersA))
+        }
+
+        for i := range ordersA {
+            if ordersA[i].ID != ordersB[i].ID || !ordersA[i].Price.Equals(ordersB[i].Price) || !ordersA[i].Quantity.Equals(ordersB[i].Quantity) {
+                return fmt.Errorf("side %v position %v holds %+v, expected %+v", side, i, ordersB[i], ordersA[i])
+            }
+        }
+    }
+    return nil
+}
+
+func TestJournalRestart(t *testing.T) {
+    dir, err := ioutil.TempDir("", "journal")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    settings := quickfix.NewSessionSettings()
+    settings.Set(JournalPath, filepath.Join(dir, "journal.log"))
+
+    e, _ := newTestExecutor(t, settings)
+    if err := e.restore(settings); err != nil {
+        t.Fatal(err)
+    }
+
+    trade(t, e, 4, 40)
+
+    session := testSession(0)
+    e.FromApp(newLimitOrder("A", enum.Side_BUY, 10, 90), session)
+    e.FromApp(newLimitOrder("B", enum.Side_BUY, 10, 90), session)
+    e.FromApp(newLimitOrder("C", enum.Side_BUY, 5, 91), session)
+    e.FromApp(newReplaceRequest("A", "A2", enum.Side_BUY, 8, 90), session)
+    e.FromApp(newReplaceRequest("B", "B2", enum.Side_BUY, 10, 91), session)
+    e.FromApp(newCancelRequest("C", "C2", enum.Side_BUY), session)
+    e.FromApp(newLimitOrder("D", enum.Side_SELL, 500, 90), session)
+    e.FromApp(newPeggedOrder("P", enum.Side_BUY, 5, enum.ExecInst_PRIMARY_PEG), session)
+    e.FromApp(newLimitOrder("E", enum.Side_BUY, 5, 89), session)
+    //status replies and duplicate ClOrdID rejects are not journaled, their ExecIDs must not be given again
+    e.FromApp(newStatusRequest("", "E", "TEST", enum.Side_BUY), session)
+    e.FromApp(newLimitOrder("E", enum.Side_BUY, 5, 89), session)
+    e.journal.Close()
+
+    restarted, out := newTestExecutor(t, settings)
+    if err := restarted.restore(settings); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := sameBook(e, restarted); err != nil {
+        t.Fatal(err)
+    }
+
+    if len(restarted.orders) != len(e.orders) {
+        t.Fatalf("restored %v orders, expected %v", len(restarted.orders), len(e.orders))
+    }
+    for i, order := range e.orders {
+        restored := restarted.orders[i]
+        if restored.OrderID != order.OrderID || restored.ClOrdID != order.ClOrdID || restored.OrderStatus != order.OrderStatus ||
+            !restored.CumQty.Equals(order.CumQty) || !restored.LeavesQty.Equals(order.LeavesQty) || !restored.AvgPx.Equals(order.AvgPx) {
+            t.Errorf("restored order %+v, expected %+v", restored, order)
+        }
+    }
+
+    //the restarted executor carries on where the first one stopped
+    restarted.FromApp(newLimitOrder("0-Y", enum.Side_SELL, 1, 90), session)
+    reply := out.last(session)
+    if execID, _ := reply.Body.GetInt(tag.ExecID); execID <= e.execID {
+        t.Errorf("ExecID %v reused after the restart, the journal had reached %v", execID, e.execID)
+    }
+    if restarted.findOrder(session, "A2") == nil {
+        t.Errorf("replaced order is not known by its ClOrdID after the restart")
+    }
+}
+
+func TestReplay(t *testing.T) {
+    settings := quickfix.NewSessionSettings()
+    settings.Set(MarketMaker, "Y")
+    settings.Set(MarketMakerVolatility, "0.5")
+
+    e, out := newTestExecutor(t, settings)
+    clock := time.Date(2026, 3, 2, 14, 30, 0, 0, time.UTC)
+    e.useClock(func() time.Time { return clock })
+
+    //the log of the original run, both directions interleaved as quickfix writes them
+    var log bytes.Buffer
+    sent := make(map[quickfix.SessionID]int)
+    for i := 0; i < 60; i++ {
+        sessionID := testSession(i % 3)
+        side := enum.Side_BUY
+        if i%2 == 1 {
+            side = enum.Side_SELL
+        }
+
+        msg := newLimitOrder(fmt.Sprintf("%v", i), side, int64(50+i), int64(99+i%3))
+        if i%7 == 6 {
+            msg = newCancelRequest(fmt.Sprintf("%v", i-3), fmt.Sprintf("%v", i), enum.Side_SELL)
+        }
+        msg.Header.SetString(tag.BeginString, sessionID.BeginString)
+        msg.Header.SetString(tag.SenderCompID, sessionID.TargetCompID)
+        msg.Header.SetString(tag.TargetCompID, sessionID.SenderCompID)
+        msg.Header.SetField(tag.SendingTime, field.NewSendingTime(clock))
+        fmt.Fprintf(&log, "%v %v\n", clock.Format("2006/01/02 15:04:05.000000"), msg)
+
+        e.advance(clock)
+        e.FromApp(msg, sessionID)
+        clock = clock.Add(1700 * time.Millisecond)
+
+        for s := 0; s < 3; s++ {
+            reports := out.executionReports(testSession(s))
+            for _, report := range reports[sent[testSession(s)]:] {
+                report.Header.SetString(tag.BeginString, enum.BeginStringFIX42)
+                report.Header.SetString(tag.SenderCompID, testSession(s).SenderCompID)
+                report.Header.SetString(tag.TargetCompID, testSession(s).TargetCompID)
+                fmt.Fprintf(&log, "%v %v\n", clock.Format("2006/01/02 15:04:05.000000"), strings.Replace(report.String(), "\001", "|", -1))
+            }
+            sent[testSession(s)] = len(reports)
+        }
+    }
+
+    messages, err := readFIXLog(&log)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    replay, _ := newTestExecutor(t, settings)
+    replayed, original := replay.replayLog(messages, "FIXIMULATOR")
+
+    for s := 0; s < 3; s++ {
+        sessionID := testSession(s)
+        if len(original[sessionID]) == 0 {
+            t.Fatalf("no execution reports of %v in the log", sessionID)
+        }
+        for _, diff := range diffReports(original[sessionID], replayed[sessionID]) {
+            t.Errorf("%v: %v", sessionID, diff)
+        }
+    }
+
+    //a report missing from the replay shifts every report after it
+    if diffs := diffReports(original[testSession(0)], replayed[testSession(0)][1:]); len(diffs) == 0 {
+        t.Errorf("a missing report went unnoticed")
+    }
+}
+
+func TestRunReplayOutput(t *testing.T) {
+    dir, err := ioutil.TempDir("", "replay")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    cfg := "[DEFAULT]\nSenderCompID=FIXIMULATOR\nPriceSource=static\n\n[PRICES]\nTEST=100,10,99,20,101,30\n\n" +
+        "[SESSION]\nBeginString=FIX.4.2\nTargetCompID=CLIENT1\n"
+    var log bytes.Buffer
+    clock := time.Date(2026, 3, 2, 14, 30, 0, 0, time.UTC)
+    for i, msg := range []*quickfix.Message{newLimitOrder("1", enum.Side_BUY, 5, 100), newLimitOrder("2", enum.Side_SELL, 3, 100)} {
+        msg.Header.SetString(tag.BeginString, enum.BeginStringFIX42)
+        msg.Header.SetString(tag.SenderCompID, "CLIENT1")
+        msg.Header.SetString(tag.TargetCompID, "FIXIMULATOR")
+        msg.Header.SetField(tag.SendingTime, field.NewSendingTime(clock.Add(time.Duration(i)*time.Second)))
+        fmt.Fprintf(&log, "%v %v\n", clock.Format("2006/01/02 15:04:05.000000"), msg)
+    }
+
+    cfgFileName, logFileName := filepath.Join(dir, "acceptor.cfg"), filepath.Join(dir, "messages.log")
+    if err := ioutil.WriteFile(cfgFileName, []byte(cfg), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := ioutil.WriteFile(logFileName, log.Bytes(), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    //the reports go to stdout when no -out is given, nothing else the executor prints may go with them
+    stdout := os.Stdout
+    r, w, err := os.Pipe()
+    if err != nil {
+        t.Fatal(err)
+    }
+    printed := make(chan []byte)
+    go func() {
+        b, _ := ioutil.ReadAll(r)
+        printed <- b
+    }()
+
+    os.Stdout = w
+    _, err = runReplay(cfgFileName, logFileName, "", w)
+    os.Stdout = stdout
+    w.Close()
+    output := <-printed
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+    if len(lines) != 4 {
+        t.Errorf("%v lines printed, expected the 4 execution reports:\n%s", len(lines), output)
+    }
+    for _, line := range lines {
+        if !strings.HasPrefix(line, "8=FIX.4.2|") || !strings.Contains(line, "|35=8|") {
+            t.Errorf("printed %q, expected an execution report", line)
+        }
+    }
+}
+
+func TestRiskChecks(t *testing.T) {
+    defaults := quickfix.NewSessionSettings()
+    defaults.Set(MaxOrderQty, "100")
+    defaults.Set(PriceBand, "5")
+
+    strict := quickfix.NewSessionSettings()
+    strict.Set(MaxOpenOrders, "2")
+    strict.Set(MaxPosition, "30")
+    strict.Set(MaxNotional, "2500")
+
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    risk, err := newRiskRules(defaults, map[quickfix.SessionID]*quickfix.SessionSettings{testSession(2): strict}, map[string]string{"TEST.MaxOrderQty": "50"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    e.risk = risk
+
+    withPegDifference := func(msg *quickfix.Message, difference int64) *quickfix.Message {
+        msg.Body.Set(field.NewPegDifference(decimal.New(difference, 0), 2))
+        return msg
+    }
+
+    tests := []struct {
+        name      string
+        session   int
+        msg       *quickfix.Message
+        status    enum.OrdStatus
+    }{
+        {"within the limits", 1, newLimitOrder("1", enum.Side_BUY, 40, 99), enum.OrdStatus_NEW},
+        {"above the MaxOrderQty of the session", 1, newLimitOrder("2", enum.Side_BUY, 150, 99), enum.OrdStatus_REJECTED},
+        {"above the MaxOrderQty of the symbol", 1, newLimitOrder("3", enum.Side_BUY, 60, 99), enum.OrdStatus_REJECTED},
+        {"outside the price band", 1, newLimitOrder("4", enum.Side_SELL, 10, 106), enum.OrdStatus_REJECTED},
+        {"inside the price band", 1, newLimitOrder("5", enum.Side_SELL, 10, 104), enum.OrdStatus_NEW},
+        {"pegged outside the price band", 1, withPegDifference(newPeggedOrder("6", enum.Side_BUY, 10, enum.ExecInst_MARKET_PEG), 3), enum.OrdStatus_REJECTED},
+        {"pegged inside the price band", 1, newPeggedOrder("7", enum.Side_BUY, 10, enum.ExecInst_PRIMARY_PEG), enum.OrdStatus_NEW},
+        {"session without a price band", 2, newLimitOrder("1", enum.Side_BUY, 10, 80), enum.OrdStatus_NEW},
+        {"above MaxNotional", 2, newLimitOrder("2", enum.Side_BUY, 30, 90), enum.OrdStatus_REJECTED},
+        {"above MaxPosition with the open orders", 2, newLimitOrder("3", enum.Side_BUY, 25, 80), enum.OrdStatus_REJECTED},
+        {"sells count against the short side", 2, newLimitOrder("4", enum.Side_SELL, 20, 110), enum.OrdStatus_NEW},
+        {"MaxOpenOrders reached", 2, newLimitOrder("5", enum.Side_SELL, 1, 120), enum.OrdStatus_REJECTED},
+        {"a cancel frees an open order", 2, newCancelRequest("4", "6", enum.Side_SELL), enum.OrdStatus_CANCELED},
+        {"below MaxOpenOrders again", 2, newLimitOrder("7", enum.Side_SELL, 1, 120), enum.OrdStatus_NEW},
+    }
+
+    for _, test := range tests {
+        sessionID := testSession(test.session)
+        if reject := e.FromApp(test.msg, sessionID); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        reply := out.last(sessionID)
+        if status, _ := reply.Body.GetString(tag.OrdStatus); status != string(test.status) {
+            text, _ := reply.Body.GetString(tag.Text)
+            t.Errorf("%v: OrdStatus %v (%v), expected %v", test.name, status, text, test.status)
+            continue
+        }
+
+        if test.status == enum.OrdStatus_REJECTED {
+            if reason, _ := reply.Body.GetString(tag.OrdRejReason); reason != string(enum.OrdRejReason_ORDER_EXCEEDS_LIMIT) {
+                t.Errorf("%v: OrdRejReason %v, expected %v", test.name, reason, enum.OrdRejReason_ORDER_EXCEEDS_LIMIT)
+            }
+            if !reply.Body.Has(tag.Text) {
+                t.Errorf("%v: reject without a Text", test.name)
+            }
+        }
+    }
+
+    //only the order checked is left out of the exposure, not every order without an OrderID yet
+    order := &Order{SessionID: testSession(3), Symbol: "TEST", Side: enum.Side_BUY, OrderStatus: enum.OrdStatus_NEW, LeavesQty: decimal.New(10, 0)}
+    other := *order
+    e.orders = append(e.orders, order, &other)
+    if x := e.exposureOf(order, order); x.openOrders != 1 || !x.buying.Equals(decimal.New(10, 0)) {
+        t.Errorf("exposure %+v, expected the other order only", x)
+    }
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+    withAccount := func(msg *quickfix.Message, account string) *quickfix.Message {
+        msg.Body.Set(field.NewAccount(account))
+        return msg
+    }
+
+    //report is an execution report written as strings
+    type report struct {
+        clOrdID  string
+        execType enum.ExecType
+        orderQty string
+        leaves   string
+    }
+
+    tests := []struct {
+        prevention string
+        key        string
+        buy, sell  *quickfix.Message
+        reports    []report
+    }{
+        {"none", "session", newLimitOrder("B", enum.Side_BUY, 10, 100), newLimitOrder("S", enum.Side_SELL, 4, 100),
+            []report{{"B", enum.ExecType_NEW, "10", "10"}, {"S", enum.ExecType_NEW, "4", "4"}, {"S", enum.ExecType_FILL, "4", "0"}, {"B", enum.ExecType_PARTIAL_FILL, "10", "6"}}},
+        {"cancel-resting", "session", newLimitOrder("B", enum.Side_BUY, 10, 100), newLimitOrder("S", enum.Side_SELL, 4, 100),
+            []report{{"B", enum.ExecType_NEW, "10", "10"}, {"S", enum.ExecType_NEW, "4", "4"}, {"B", enum.ExecType_CANCELED, "10", "0"}}},
+        {"cancel-aggressor", "session", newLimitOrder("B", enum.Side_BUY, 10, 100), newLimitOrder("S", enum.Side_SELL, 4, 100),
+            []report{{"B", enum.ExecType_NEW, "10", "10"}, {"S", enum.ExecType_NEW, "4", "4"}, {"S", enum.ExecType_CANCELED, "4", "0"}}},
+        {"cancel-both", "session", newLimitOrder("B", enum.Side_BUY, 10, 100), newLimitOrder("S", enum.Side_SELL, 4, 100),
+            []report{{"B", enum.ExecType_NEW, "10", "10"}, {"S", enum.ExecType_NEW, "4", "4"}, {"S", enum.ExecType_CANCELED, "4", "0"}, {"B", enum.ExecType_CANCELED, "10", "0"}}},
+        {"decrement-and-cancel", "session", newLimitOrder("B", enum.Side_BUY, 10, 100), newLimitOrder("S", enum.Side_SELL, 4, 100),
+            []report{{"B", enum.ExecType_NEW, "10", "10"}, {"S", enum.ExecType_NEW, "4", "4"}, {"S", enum.ExecType_CANCELED, "4", "0"}, {"B", enum.ExecType_RESTATED, "6", "6"}}},
+        {"cancel-both", "account", withAccount(newLimitOrder("B", enum.Side_BUY, 10, 100), "X"), withAccount(newLimitOrder("S", enum.Side_SELL, 4, 100), "Y"),
+            []report{{"B", enum.ExecType_NEW, "10", "10"}, {"S", enum.ExecType_NEW, "4", "4"}, {"S", enum.ExecType_FILL, "4", "0"}, {"B", enum.ExecType_PARTIAL_FILL, "10", "6"}}},
+    }
+
+    for _, test := range tests {
+        settings := quickfix.NewSessionSettings()
+        settings.Set(SelfTradePrevention, test.prevention)
+        settings.Set(SelfTradePreventionKey, test.key)
+
+        e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+        rules, err := newSelfTradeRules(settings, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        e.selfTrade = rules
+
+        sessionID := testSession(1)
+        e.FromApp(test.buy, sessionID)
+        e.FromApp(test.sell, sessionID)
+
+        var reports []report
+        for _, msg := range out.executionReports(sessionID) {
+            var r report
+            var orderQty field.OrderQtyField
+            var leavesQty field.LeavesQtyField
+            var execType field.ExecTypeField
+            msg.Body.Get(&orderQty)
+            msg.Body.Get(&leavesQty)
+            msg.Body.Get(&execType)
+            r.clOrdID, _ = msg.Body.GetString(tag.ClOrdID)
+            r.execType = execType.Value()
+            r.orderQty = orderQty.Value().String()
+            r.leaves = leavesQty.Value().String()
+            reports = append(reports, r)
+        }
+
+        if !reflect.DeepEqual(reports, test.reports) {
+            t.Errorf("%v by %v: reports %v, expected %v", test.prevention, test.key, reports, test.reports)
+        }
+    }
+}
+
+func TestMinQtyAndAllOrNone(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    buyer, seller := testSession(1), testSession(2)
+
+    withMinQty := func(msg *quickfix.Message, minQty int64) *quickfix.Message {
+        msg.Body.Set(field.NewMinQty(decimal.New(minQty, 0), 0))
+        return msg
+    }
+    allOrNone := func(msg *quickfix.Message) *quickfix.Message {
+        msg.Body.Set(field.NewExecInst(enum.ExecInst_ALL_OR_NONE))
+        return msg
+    }
+    immediate := func(msg *quickfix.Message) *quickfix.Message {
+        msg.Body.Set(field.NewTimeInForce(enum.TimeInForce_IMMEDIATE_OR_CANCEL))
+        return msg
+    }
+
+    e.FromApp(allOrNone(newLimitOrder("S1", enum.Side_SELL, 50, 100)), seller)
+    e.FromApp(newLimitOrder("S2", enum.Side_SELL, 20, 100), seller)
+
+    tests := []struct {
+        name   string
+        msg    *quickfix.Message
+        status enum.OrdStatus
+        cumQty string
+    }{
+        {"MinQty above what smaller orders can fill", withMinQty(newLimitOrder("B1", enum.Side_BUY, 30, 100), 25), enum.OrdStatus_REJECTED, "0"},
+        {"all or none passed over by a smaller order", withMinQty(newLimitOrder("B2", enum.Side_BUY, 30, 100), 20), enum.OrdStatus_PARTIALLY_FILLED, "20"},
+        {"immediate all or none larger than the book", allOrNone(immediate(newLimitOrder("B3", enum.Side_BUY, 60, 100))), enum.OrdStatus_REJECTED, "0"},
+        {"immediate all or none filled by the all or none order", allOrNone(immediate(newLimitOrder("B4", enum.Side_BUY, 50, 100))), enum.OrdStatus_FILLED, "50"},
+    }
+
+    for _, test := range tests {
+        if reject := e.FromApp(test.msg, buyer); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        reply := out.last(buyer)
+        if status, _ := reply.Body.GetString(tag.OrdStatus); status != string(test.status) {
+            text, _ := reply.Body.GetString(tag.Text)
+            t.Errorf("%v: OrdStatus %v (%v), expected %v", test.name, status, text, test.status)
+        }
+
+        var cumQty field.CumQtyField
+        reply.Body.Get(&cumQty)
+        if cumQty.Value().String() != test.cumQty {
+            t.Errorf("%v: CumQty %v, expected %v", test.name, cumQty.Value(), test.cumQty)
+        }
+    }
+
+    //orders of its own the book would cancel under self-trade prevention cannot fill a FILL_OR_KILL or an all
+    //or none order
+    fillOrKill := func(msg *quickfix.Message) *quickfix.Message {
+        msg.Body.Set(field.NewTimeInForce(enum.TimeInForce_FILL_OR_KILL))
+        return msg
+    }
+    prevention := quickfix.NewSessionSettings()
+    prevention.Set(SelfTradePrevention, "cancel-resting")
+
+    for _, msg := range []*quickfix.Message{fillOrKill(newLimitOrder("B5", enum.Side_BUY, 100, 103)), allOrNone(newLimitOrder("B5", enum.Side_BUY, 100, 103))} {
+        e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+        rules, err := newSelfTradeRules(prevention, nil)
+        if err != nil {
+            t.Fatal(err)
+        }
+        e.selfTrade = rules
+
+        e.FromApp(newLimitOrder("S1", enum.Side_SELL, 50, 102), buyer)
+        e.FromApp(newLimitOrder("S2", enum.Side_SELL, 50, 103), seller)
+        e.FromApp(msg, buyer)
+
+        timeInForce, _ := msg.Body.GetString(tag.TimeInForce)
+        expected := []enum.ExecType{enum.ExecType_NEW, enum.ExecType_REJECTED}
+        if timeInForce != string(enum.TimeInForce_FILL_OR_KILL) {
+            expected = []enum.ExecType{enum.ExecType_NEW, enum.ExecType_NEW}
+        }
+
+        var execTypes []enum.ExecType
+        for _, report := range out.executionReports(buyer) {
+            var execType field.ExecTypeField
+            report.Body.Get(&execType)
+            execTypes = append(execTypes, execType.Value())
+        }
+        if !reflect.DeepEqual(execTypes, expected) {
+            t.Errorf("TimeInForce %v against its own order: reports %v, expected %v", timeInForce, execTypes, expected)
+        }
+        if len(out.executionReports(seller)) != 1 {
+            t.Errorf("TimeInForce %v against its own order: %v reports to the seller, expected 1", timeInForce, len(out.executionReports(seller)))
+        }
+    }
+}
+
+func newPeggedOrder(clOrdID string, side enum.Side, qty int64, peg enum.ExecInst) *quickfix.Message {
+    order := fix42nos.New(
+        field.NewClOrdID(clOrdID),
+        field.NewHandlInst(enum.HandlInst_AUTOMATED_EXECUTION_ORDER_PRIVATE_NO_BROKER_INTERVENTION),
+        field.NewSymbol("TEST"),
+        field.NewSide(side),
+        field.NewTransactTime(time.Now()),
+        field.NewOrdType(enum.OrdType_PEGGED),
+    )
+    order.SetOrderQty(decimal.New(qty, 0), 0)
+    order.SetExecInst(peg)
+    order.SetTimeInForce(enum.TimeInForce_GOOD_TILL_CANCEL)
+    return order.ToMessage()
+}
+
+func TestPeggedOrders(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    client, pegs := testSession(1), testSession(2)
+
+    e.FromApp(newLimitOrder("S1", enum.Side_SELL, 10, 102), client)
+    e.FromApp(newLimitOrder("B1", enum.Side_BUY, 10, 98), client)
+
+    //expect checks the last report of clOrdID and the price it rests at
+    expect := func(step string, clOrdID string, execType enum.ExecType, price string) {
+        reply := out.lastOf(pegs, clOrdID)
+        if reply == nil {
+            t.Fatalf("%v: no report of %v", step, clOrdID)
+        }
+
+        if got, _ := reply.Body.GetString(tag.ExecType); got != string(execType) {
+            t.Errorf("%v: %v reported ExecType %v, expected %v", step, clOrdID, got, execType)
+        }
+        if got, _ := reply.Body.GetString(tag.Price); !sameValue(got, price) {
+            t.Errorf("%v: %v reported Price %v, expected %v", step, clOrdID, got, price)
+        }
+    }
+
+    e.FromApp(newPeggedOrder("P1", enum.Side_BUY, 10, enum.ExecInst_PRIMARY_PEG), pegs)
+    e.FromApp(newPeggedOrder("P2", enum.Side_SELL, 5, enum.ExecInst_MID_PRICE_PEG), pegs)
+    expect("arrival", "P1", enum.ExecType_NEW, "98")
+    expect("arrival", "P2", enum.ExecType_NEW, "100")
+
+    if reject := e.FromApp(newPeggedOrder("P0", enum.Side_BUY, 10, enum.ExecInst_ALL_OR_NONE), pegs); reject == nil {
+        t.Errorf("a pegged order without a peg ExecInst should be rejected")
+    }
+
+    e.FromApp(newLimitOrder("B2", enum.Side_BUY, 10, 99), client)
+    expect("better bid", "P1", enum.ExecType_RESTATED, "99")
+    expect("better bid", "P2", enum.ExecType_RESTATED, "100.5")
+
+    var queue []string
+    for _, o := range e.quotes["TEST"].book.Orders(orderbook.Buy) {
+        queue = append(queue, e.orderIDs[o.ID].ClOrdID)
+    }
+    if expected := []string{"B2", "P1", "B1"}; !reflect.DeepEqual(queue, expected) {
+        t.Errorf("bids %v, expected the moved peg behind the order it follows %v", queue, expected)
+    }
+
+    e.FromApp(newCancelRequest("B2", "B2-C", enum.Side_BUY), client)
+    expect("bid cancelled", "P1", enum.ExecType_RESTATED, "98")
+    expect("bid cancelled", "P2", enum.ExecType_RESTATED, "100")
+
+    //a market peg follows the other side, it trades as soon as it is priced
+    e.FromApp(newPeggedOrder("P3", enum.Side_SELL, 5, enum.ExecInst_MARKET_PEG), pegs)
+    expect("market peg", "P3", enum.ExecType_FILL, "98")
+
+    if reply := out.last(client); reply == nil {
+        t.Fatal("no report to the resting order")
+    } else if id, _ := reply.Body.GetString(tag.ClOrdID); id != "B1" {
+        t.Errorf("the market peg traded with %v, expected B1 ahead of the pegged bid", id)
+    }
+}
+
+func TestTradingPhases(t *testing.T) {
+    settings := quickfix.NewSessionSettings()
+    settings.Set(PreOpenTime, "09:00:00")
+    settings.Set(OpenTime, "09:30:00")
+    settings.Set(ClosingAuctionTime, "15:50:00")
+    settings.Set(MarketCloseTime, "16:00:00")
+
+    e, out := newTestExecutor(t, settings)
+    phases, err := newPhaseRules(settings, nil, e.marketClose)
+    if err != nil {
+        t.Fatal(err)
+    }
+    e.phases = phases
+
+    day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+    clock := day.Add(8 * time.Hour)
+    e.useClock(func() time.Time { return clock })
+    at := func(offset time.Duration) {
+        clock = day.Add(offset)
+        e.advance(clock)
+    }
+
+    session := testSession(1)
+    withTimeInForce := func(msg *quickfix.Message, timeInForce enum.TimeInForce) *quickfix.Message {
+        msg.Body.Set(field.NewTimeInForce(timeInForce))
+        return msg
+    }
+    expect := func(step string, clOrdID string, status enum.OrdStatus, cumQty string) {
+        reply := out.lastOf(session, clOrdID)
+        if reply == nil {
+            t.Fatalf("%v: no report of %v", step, clOrdID)
+        }
+        if got, _ := reply.Body.GetString(tag.OrdStatus); got != string(status) {
+            text, _ := reply.Body.GetString(tag.Text)
+            t.Errorf("%v: %v is %v (%v), expected %v", step, clOrdID, got, text, status)
+        }
+        if got, _ := reply.Body.GetString(tag.CumQty); !sameValue(got, cumQty) {
+            t.Errorf("%v: %v filled %v, expected %v", step, clOrdID, got, cumQty)
+        }
+    }
+    expectAuction := func(step string, entryType enum.MDEntryType, price string, volume string) {
+        levels := e.quotes["TEST"].levels(entryType, 0)
+        if len(levels) != 1 || !sameValue(levels[0].price.String(), price) || !sameValue(levels[0].size.String(), volume) {
+            t.Errorf("%v: auction %v %+v, expected %v@%v", step, entryType, levels, volume, price)
+        }
+    }
+
+    e.FromApp(newLimitOrder("X1", enum.Side_BUY, 10, 100), session)
+    expect("closed", "X1", enum.OrdStatus_REJECTED, "0")
+
+    at(9*time.Hour + 10*time.Minute)
+    market := fix42nos.New(
+        field.NewClOrdID("B2"),
+        field.NewHandlInst(enum.HandlInst_AUTOMATED_EXECUTION_ORDER_PRIVATE_NO_BROKER_INTERVENTION),
+        field.NewSymbol("TEST"),
+        field.NewSide(enum.Side_BUY),
+        field.NewTransactTime(time.Now()),
+        field.NewOrdType(enum.OrdType_MARKET),
+    )
+    market.SetOrderQty(decimal.New(5, 0), 0)
+
+    e.FromApp(withTimeInForce(newLimitOrder("B1", enum.Side_BUY, 10, 101), enum.TimeInForce_AT_THE_OPENING), session)
+    e.FromApp(withTimeInForce(newLimitOrder("B3", enum.Side_BUY, 5, 90), enum.TimeInForce_AT_THE_OPENING), session)
+    e.FromApp(withTimeInForce(newLimitOrder("S1", enum.Side_SELL, 5, 99), enum.TimeInForce_DAY), session)
+    e.FromApp(withTimeInForce(market.ToMessage(), enum.TimeInForce_DAY), session)
+    e.FromApp(withTimeInForce(newLimitOrder("S2", enum.Side_SELL, 20, 100), enum.TimeInForce_DAY), session)
+    e.FromApp(withTimeInForce(newLimitOrder("C1", enum.Side_SELL, 5, 100), enum.TimeInForce_AT_THE_CLOSE), session)
+
+    expect("pre-open", "S1", enum.OrdStatus_NEW, "0")
+    expect("pre-open", "B2", enum.OrdStatus_NEW, "0")
+    expectAuction("pre-open", enum.MDEntryType_OPENING_PRICE, "100", "15")
+
+    at(9*time.Hour + 30*time.Minute)
+    expect("open", "B1", enum.OrdStatus_FILLED, "10")
+    expect("open", "B2", enum.OrdStatus_FILLED, "5")
+    expect("open", "S1", enum.OrdStatus_FILLED, "5")
+    expect("open", "S2", enum.OrdStatus_PARTIALLY_FILLED, "10")
+    expect("open", "B3", enum.OrdStatus_EXPIRED, "0")
+    expect("open", "C1", enum.OrdStatus_NEW, "0")
+    expectAuction("open", enum.MDEntryType_OPENING_PRICE, "100", "15")
+
+    e.FromApp(withTimeInForce(newLimitOrder("B5", enum.Side_BUY, 5, 90), enum.TimeInForce_AT_THE_OPENING), session)
+    expect("continuous", "B5", enum.OrdStatus_REJECTED, "0")
+
+    at(15*time.Hour + 50*time.Minute)
+    e.FromApp(withTimeInForce(newLimitOrder("B4", enum.Side_BUY, 15, 100), enum.TimeInForce_DAY), session)
+    expect("closing call", "B4", enum.OrdStatus_NEW, "0")
+    expectAuction("closing call", enum.MDEntryType_CLOSING_PRICE, "100", "15")
+
+    at(16 * time.Hour)
+    expect("close", "B4", enum.OrdStatus_FILLED, "15")
+    expect("close", "S2", enum.OrdStatus_FILLED, "20")
+    expect("close", "C1", enum.OrdStatus_FILLED, "5")
+
+    e.FromApp(newLimitOrder("X2", enum.Side_BUY, 10, 100), session)
+    expect("after the close", "X2", enum.OrdStatus_REJECTED, "0")
+}
+
+func TestMarketMakerRules(t *testing.T) {
+    defaults := quickfix.NewSessionSettings()
+    defaults.Set(MarketMaker, "Y")
+    defaults.Set(MarketMakerSize, "100")
+
+    rules, err := newMarketMakerRules(defaults, map[string]string{"TEST.MarketMakerSize": "7", "TEST.MarketMakerModel": "gbm", "OTHER.MarketMaker": "N"})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    if c := rules.of("TEST"); c == nil || c.size.String() != "7" || c.model != "gbm" {
+        t.Errorf("TEST quotes %+v, expected 7 on a gbm", c)
+    }
+    if c := rules.of("ANY"); c == nil || c.size.String() != "100" || c.model != "walk" {
+        t.Errorf("ANY quotes %+v, expected the defaults", c)
+    }
+    if c := rules.of("OTHER"); c != nil {
+        t.Errorf("OTHER quotes %+v, expected no market maker", c)
+    }
+
+    //a symbol may have a market maker of its own when the others have none
+    rules, err = newMarketMakerRules(quickfix.NewSessionSettings(), map[string]string{"TEST.MarketMaker": "Y"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    if rules.of("TEST") == nil || rules.of("ANY") != nil {
+        t.Errorf("market makers TEST %+v ANY %+v, expected only TEST", rules.of("TEST"), rules.of("ANY"))
+    }
+
+    for _, section := range []map[string]string{{"TEST.MarketMakerModel": "random"}, {"TEST.MarketMakerTick": "0"}, {"MarketMakerSize": "1"}} {
+        if _, err := newMarketMakerRules(defaults, section); err == nil {
+            t.Errorf("%v accepted", section)
+        }
+    }
+}
+
+func TestMarketMakerPaths(t *testing.T) {
+    path := func(model string, symbol string) (mids []float64) {
+        settings := quickfix.NewSessionSettings()
+        settings.Set(MarketMaker, "Y")
+        settings.Set(MarketMakerModel, model)
+        settings.Set(MarketMakerSeed, "42")
+
+        c, err := newMarketMakerConfig(settings)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        m := newMarketMaker(c, &Quote{symbol: symbol}, decimal.New(100, 0))
+        for i := 0; i < 50; i++ {
+            m.step()
+            mids = append(mids, m.mid)
+        }
+        return
+    }
+
+    for _, model := range []string{"walk", "gbm"} {
+        if !reflect.DeepEqual(path(model, "TEST"), path(model, "TEST")) {
+            t.Errorf("%v: the same seed took two paths", model)
+        }
+        if reflect.DeepEqual(path(model, "TEST"), path(model, "OTHER")) {
+            t.Errorf("%v: two symbols took the same path", model)
+        }
+    }
+}
+
+func TestMarketMakerTick(t *testing.T) {
+    settings := quickfix.NewSessionSettings()
+    settings.Set(MarketMaker, "Y")
+    settings.Set(MarketMakerVolatility, "0.37")
+    settings.Set(MarketMakerTick, "0.05")
+    settings.Set(MarketMakerSpread, "0.10")
+
+    e, _ := newTestExecutor(t, settings)
+    clock := time.Date(2026, 3, 2, 14, 30, 0, 0, time.UTC)
+    e.useClock(func() time.Time { return clock })
+
+    stock, err := e.getQuote("TEST")
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    tick := decimal.New(5, -2)
+    for i := 0; i < 20; i++ {
+        clock = clock.Add(time.Second)
+        e.advance(clock)
+
+        for _, side := range []orderbook.Side{orderbook.Buy, orderbook.Sell} {
+            for _, order := range stock.book.Orders(side) {
+                if !order.Price.Mod(tick).Equals(decimal.Zero) {
+                    t.Fatalf("step %v: quote %v at %v is off the tick", i, order.ID, order.Price)
+                }
+            }
+        }
+    }
+}
+
+//messagesOfType returns the messages of msgType sent to sessionID
+func (o *outbox) messagesOfType(sessionID quickfix.SessionID, msgType enum.MsgType) (messages []*quickfix.Message) {
+    o.Lock()
+    defer o.Unlock()
+    for _, m := range o.messages[sessionID] {
+        if t, _ := m.Header.GetString(tag.MsgType); t == string(msgType) {
+            messages = append(messages, m)
+        }
+    }
+    return
+}
+
+func newMarketDataRequest(mdReqID string, subscriptionType enum.SubscriptionRequestType, depth int, symbols ...string) *quickfix.Message {
+    request := fix42mdr.New(field.NewMDReqID(mdReqID), field.NewSubscriptionRequestType(subscriptionType), field.NewMarketDepth(depth))
+
+    entryTypes := fix42mdr.NewNoMDEntryTypesRepeatingGroup()
+    for _, entryType := range []enum.MDEntryType{enum.MDEntryType_BID, enum.MDEntryType_OFFER, enum.MDEntryType_TRADE} {
+        entryTypes.Add().SetMDEntryType(entryType)
+    }
+    request.SetNoMDEntryTypes(entryTypes)
+
+    relatedSym := fix42mdr.NewNoRelatedSymRepeatingGroup()
+    for _, symbol := range symbols {
+        relatedSym.Add().SetSymbol(symbol)
+    }
+    request.SetNoRelatedSym(relatedSym)
+    return request.ToMessage()
+}
+
+//mdEntry is a market data entry written as strings, action is empty in snapshots
+type mdEntry struct {
+    action    enum.MDUpdateAction
+    entryType enum.MDEntryType
+    price     string
+    size      string
+    position  int
+}
+
+func readMDEntry(entry quickfix.FieldMap) (e mdEntry) {
+    if action, err := entry.GetString(tag.MDUpdateAction); err == nil {
+        e.action = enum.MDUpdateAction(action)
+    }
+    entryType, _ := entry.GetString(tag.MDEntryType)
+    e.entryType = enum.MDEntryType(entryType)
+
+    for value, t := range map[*string]quickfix.Tag{&e.price: tag.MDEntryPx, &e.size: tag.MDEntrySize} {
+        s, _ := entry.GetString(t)
+        d, _ := decimal.NewFromString(s)
+        *value = d.String()
+    }
+    e.position, _ = entry.GetInt(tag.MDEntryPositionNo)
+    return
+}
+
+//snapshotEntries returns the symbol and entries of a full refresh
+func snapshotEntries(msg *quickfix.Message) (symbol string, entries []mdEntry) {
+    symbol, _ = msg.Body.GetString(tag.Symbol)
+    group, _ := fix42md.FromMessage(msg).GetNoMDEntries()
+    for i := 0; i < group.Len(); i++ {
+        entries = append(entries, readMDEntry(group.Get(i).FieldMap))
+    }
+    return
+}
+
+//incrementalEntries returns the entries of an incremental refresh
+func incrementalEntries(msg *quickfix.Message) (entries []mdEntry) {
+    group := newIncrementalEntries()
+    msg.Body.GetGroup(group)
+    for i := 0; i < group.Len(); i++ {
+        entries = append(entries, readMDEntry(group.Get(i).FieldMap))
+    }
+    return
+}
+
+func TestMarketDataSnapshots(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    e.prices = staticSource{
+        "TEST":  {Last: decimal.New(100, 0), Bid: decimal.New(99, 0), Ask: decimal.New(101, 0)},
+        "OTHER": {Last: decimal.New(50, 0), Bid: decimal.New(49, 0), Ask: decimal.New(51, 0)},
+    }
+    client, viewer := testSession(1), testSession(2)
+
+    e.FromApp(newLimitOrder("1", enum.Side_BUY, 5, 99), client)
+    e.FromApp(newLimitOrder("2", enum.Side_BUY, 3, 99), client)
+    e.FromApp(newLimitOrder("3", enum.Side_BUY, 4, 98), client)
+    e.FromApp(newLimitOrder("4", enum.Side_BUY, 2, 97), client)
+
+    tests := []struct {
+        name    string
+        request *quickfix.Message
+        symbols []string
+        entries [][]mdEntry
+    }{
+        {
+            name:    "MarketDepth 2 aggregates the orders of each price",
+            request: newMarketDataRequest("A", enum.SubscriptionRequestType_SNAPSHOT, 2, "TEST"),
+            symbols: []string{"TEST"},
+            entries: [][]mdEntry{{
+                {"", enum.MDEntryType_BID, "99", "8", 1}, {"", enum.MDEntryType_BID, "98", "4", 2},
+                {"", enum.MDEntryType_OFFER, "105", "12", 1},
+                {"", enum.MDEntryType_TRADE, "100", "0", 0},
+            }},
+        },
+        {
+            name:    "MarketDepth 0 is the full book",
+            request: newMarketDataRequest("B", enum.SubscriptionRequestType_SNAPSHOT, 0, "TEST"),
+            symbols: []string{"TEST"},
+            entries: [][]mdEntry{{
+                {"", enum.MDEntryType_BID, "99", "8", 1}, {"", enum.MDEntryType_BID, "98", "4", 2}, {"", enum.MDEntryType_BID, "97", "2", 3},
+                {"", enum.MDEntryType_OFFER, "105", "12", 1},
+                {"", enum.MDEntryType_TRADE, "100", "0", 0},
+            }},
+        },
+        {
+            name:    "one snapshot per symbol",
+            request: newMarketDataRequest("C", enum.SubscriptionRequestType_SNAPSHOT, 1, "TEST", "OTHER"),
+            symbols: []string{"TEST", "OTHER"},
+            entries: [][]mdEntry{
+                {{"", enum.MDEntryType_BID, "99", "8", 1}, {"", enum.MDEntryType_OFFER, "105", "12", 1}, {"", enum.MDEntryType_TRADE, "100", "0", 0}},
+                {{"", enum.MDEntryType_OFFER, "55", "12", 1}, {"", enum.MDEntryType_TRADE, "50", "0", 0}},
+            },
+        },
+    }
+
+    for _, test := range tests {
+        sent := len(out.messagesOfType(viewer, enum.MsgType_MARKET_DATA_SNAPSHOT_FULL_REFRESH))
+        if reject := e.FromApp(test.request, viewer); reject != nil {
+            t.Errorf("%v: rejected %v", test.name, reject)
+            continue
+        }
+
+        snapshots := out.messagesOfType(viewer, enum.MsgType_MARKET_DATA_SNAPSHOT_FULL_REFRESH)[sent:]
+        if len(snapshots) != len(test.symbols) {
+            t.Errorf("%v: %v snapshots, expected %v", test.name, len(snapshots), len(test.symbols))
+            continue
+        }
+        for i, snapshot := range snapshots {
+            symbol, entries := snapshotEntries(snapshot)
+            if symbol != test.symbols[i] || !reflect.DeepEqual(entries, test.entries[i]) {
+                t.Errorf("%v: %v %v, expected %v %v", test.name, symbol, entries, test.symbols[i], test.entries[i])
+            }
+        }
+    }
+
+    //a request with a symbol the acceptor does not know is rejected as a whole
+    sent := len(out.messagesOfType(viewer, enum.MsgType_MARKET_DATA_SNAPSHOT_FULL_REFRESH))
+    e.FromApp(newMarketDataRequest("D", enum.SubscriptionRequestType_SNAPSHOT, 1, "TEST", "UNKNOWN"), viewer)
+    if snapshots := out.messagesOfType(viewer, enum.MsgType_MARKET_DATA_SNAPSHOT_FULL_REFRESH); len(snapshots) != sent {
+        t.Errorf("unknown symbol: %v snapshots sent", len(snapshots)-sent)
+    }
+    if reason, _ := out.last(viewer).Body.GetString(tag.MDReqRejReason); reason != string(enum.MDReqRejReason_UNKNOWN_SYMBOL) {
+        t.Errorf("unknown symbol: MDReqRejReason %v, expected %v", reason, enum.MDReqRejReason_UNKNOWN_SYMBOL)
+    }
+}
+
+func TestMarketDataSubscriptions(t *testing.T) {
+    e, out := newTestExecutor(t, quickfix.NewSessionSettings())
+    subscriber, seller, buyer := testSession(1), testSession(2), testSession(3)
+
+    e.FromApp(newLimitOrder("1", enum.Side_BUY, 5, 99), buyer)
+    e.FromApp(newMarketDataRequest("SUB", enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES, 3, "TEST"), subscriber)
+    if snapshots := out.messagesOfType(subscriber, enum.MsgType_MARKET_DATA_SNAPSHOT_FULL_REFRESH); len(snapshots) != 1 {
+        t.Fatalf("%v snapshots on subscribing, expected 1", len(snapshots))
+    }
+
+    //an MDReqID already subscribed is rejected
+    e.FromApp(newMarketDataRequest("SUB", enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES, 3, "TEST"), subscriber)
+    if reason, _ := out.last(subscriber).Body.GetString(tag.MDReqRejReason); reason != string(enum.MDReqRejReason_DUPLICATE_MDREQID) {
+        t.Errorf("duplicate MDReqID: MDReqRejReason %v, expected %v", reason, enum.MDReqRejReason_DUPLICATE_MDREQID)
+    }
+
+    //levels are updated by price, the levels they push down or pull up are not republished
+    steps := []struct {
+        name      string
+        msg       *quickfix.Message
+        sessionID quickfix.SessionID
+        entries   []mdEntry
+    }{
+        {
+            name: "a new best offer", msg: newLimitOrder("2", enum.Side_SELL, 3, 104), sessionID: seller,
+            entries: []mdEntry{
+                {enum.MDUpdateAction_NEW, enum.MDEntryType_OFFER, "104", "3", 1},
+            },
+        },
+        {
+            name: "a trade takes the best offer", msg: newLimitOrder("3", enum.Side_BUY, 3, 104), sessionID: buyer,
+            entries: []mdEntry{
+                {enum.MDUpdateAction_DELETE, enum.MDEntryType_OFFER, "104", "3", 1},
+                {enum.MDUpdateAction_NEW, enum.MDEntryType_TRADE, "104", "3", 0},
+            },
+        },
+        {
+            name: "a new level at the bottom", msg: newLimitOrder("4", enum.Side_BUY, 1, 90), sessionID: buyer,
+            entries: []mdEntry{
+                {enum.MDUpdateAction_NEW, enum.MDEntryType_BID, "90", "1", 2},
+            },
+        },
+        {
+            name: "a new level in the middle", msg: newLimitOrder("8", enum.Side_BUY, 1, 95), sessionID: buyer,
+            entries: []mdEntry{
+                {enum.MDUpdateAction_NEW, enum.MDEntryType_BID, "95", "1", 2},
+            },
+        },
+        {
+            name: "a level below the depth is not published", msg: newLimitOrder("7", enum.Side_BUY, 1, 89), sessionID: buyer,
+        },
+        {
+            name: "a second order at a level changes its size", msg: newLimitOrder("5", enum.Side_BUY, 2, 99), sessionID: buyer,
+            entries: []mdEntry{
+                {enum.MDUpdateAction_CHANGE, enum.MDEntryType_BID, "99", "7", 1},
+            },
+        },
+        {
+            name: "a level leaving the book is deleted and the one below the depth comes in", msg: newCancelRequest("8", "9", enum.Side_BUY), sessionID: buyer,
+            entries: []mdEntry{
+                {enum.MDUpdateAction_DELETE, enum.MDEntryType_BID, "95", "1", 2},
+                {enum.MDUpdateAction_NEW, enum.MDEntryType_BID, "89", "1", 3},
+            },
+        },
+        {
+            name: "unsubscribed", msg: newMarketDataRequest("SUB", enum.SubscriptionRequestType_DISABLE_PREVIOUS_SNAPSHOT_PLUS_UPDATE_REQUEST, 0, "TEST"), sessionID: subscriber,
+        },
+        {
+            name: "nothing is published once unsubscribed", msg: newLimitOrder("6", enum.Side_BUY, 1, 100), sessionID: buyer,
+        },
+    }
+
+    for _, step := range steps {
+        sent := len(out.messagesOfType(subscriber, enum.MsgType_MARKET_DATA_INCREMENTAL_REFRESH))
+        e.FromApp(step.msg, step.sessionID)
+
+        refreshes := out.messagesOfType(subscriber, enum.MsgType_MARKET_DATA_INCREMENTAL_REFRESH)[sent:]
+        var entries []mdEntry
+        for _, refresh := range refreshes {
+            if mdReqID, _ := refresh.Body.GetString(tag.MDReqID); mdReqID != "SUB" {
+                t.Errorf("%v: MDReqID %v, expected SUB", step.name, mdReqID)
+            }
+            entries = append(entries, incrementalEntries(refresh)...)
+        }
+        if len(refreshes) > 1 || !reflect.DeepEqual(entries, step.entries) {
+            t.Errorf("%v: %v refreshes of %v, expected %v", step.name, len(refreshes), entries, step.entries)
+        }
+    }
+}
+
+func TestReadPrices(t *testing.T) {
+    test := ReferencePrice{Last: decimal.New(100, 0), LastSize: decimal.New(10, 0), Bid: decimal.New(99, 0), BidSize: decimal.New(20, 0), Ask: decimal.New(101, 0), AskSize: decimal.New(30, 0)}
+    other := ReferencePrice{Last: decimal.New(505, -1), LastSize: decimal.New(1, 0), Bid: decimal.New(50, 0), BidSize: decimal.New(2, 0), Ask: decimal.New(51, 0), AskSize: decimal.New(3, 0)}
+
+    tests := []struct {
+        name   string
+        read   func(io.Reader) (staticSource, error)
+        file   string
+        prices staticSource
+    }{
+        {"csv with a header", readCSVPrices, "Symbol,Last,LastSize,Bid,BidSize,Ask,AskSize\nTEST,100,10,99,20,101,30\nOTHER,50.5,1,50,2,51,3\n", staticSource{"TEST": test, "OTHER": other}},
+        {"csv without a header", readCSVPrices, "TEST, 100, 10, 99, 20, 101, 30\n", staticSource{"TEST": test}},
+        {"csv with a malformed price", readCSVPrices, "Symbol,Last,LastSize,Bid,BidSize,Ask,AskSize\nTEST,abc,10,99,20,101,30\n", nil},
+        {"csv with a missing column", readCSVPrices, "TEST,100,10,99,20,101\n", nil},
+        {"csv with rows of different lengths", readCSVPrices, "TEST,100,10,99,20,101,30\nOTHER,50\n", nil},
+        {"json", readJSONPrices, `{"TEST": {"Last": "100", "LastSize": "10", "Bid": "99", "BidSize": "20", "Ask": "101", "AskSize": "30"}}`, staticSource{"TEST": test}},
+        {"json with a malformed price", readJSONPrices, `{"TEST": {"Last": "abc"}}`, nil},
+        {"json that is not an object", readJSONPrices, `["TEST"]`, nil},
+    }
+
+    for _, test := range tests {
+        prices, err := test.read(strings.NewReader(test.file))
+        if test.prices == nil {
+            if err == nil {
+                t.Errorf("%v: read %v, expected an error", test.name, prices)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%v: %v", test.name, err)
+            continue
+        }
+        if err := samePrices(prices, test.prices); err != nil {
+            t.Errorf("%v: %v", test.name, err)
+        }
+    }
+}
+
+//samePrices reports the first difference between two sets of prices
+func samePrices(actual staticSource, expected staticSource) error {
+    if len(actual) != len(expected) {
+        return fmt.Errorf("%v prices, expected %v", len(actual), len(expected))
+    }
+    for symbol, e := range expected {
+        a, ok := actual[symbol]
+        if !ok || !a.Last.Equals(e.Last) || !a.LastSize.Equals(e.LastSize) || !a.Bid.Equals(e.Bid) ||
+            !a.BidSize.Equals(e.BidSize) || !a.Ask.Equals(e.Ask) || !a.AskSize.Equals(e.AskSize) {
+            return fmt.Errorf("%v is %+v, expected %+v", symbol, a, e)
+        }
+    }
+    return nil
+}
+
+func TestNewPriceSource(t *testing.T) {
+    dir, err := ioutil.TempDir("", "prices")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    files := map[string]string{
+        "prices.csv":  "Symbol,Last,LastSize,Bid,BidSize,Ask,AskSize\nTEST,100,10,99,20,101,30\n",
+        "prices.json": `{"TEST": {"Last": "100", "LastSize": "10", "Bid": "99", "BidSize": "20", "Ask": "101", "AskSize": "30"}}`,
+        "prices.txt":  "TEST,100,10,99,20,101,30\n",
+    }
+    for name, content := range files {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    tests := []struct {
+        name     string
+        settings map[string]string
+        section  map[string]string
+        valid    bool
+    }{
+        {"static", map[string]string{PriceSourceSetting: "static"}, map[string]string{"TEST": "100,10,99,20,101,30"}, true},
+        {"static with a malformed price", map[string]string{PriceSourceSetting: "static"}, map[string]string{"TEST": "100,10,99,abc,101,30"}, false},
+        {"static with a missing value", map[string]string{PriceSourceSetting: "static"}, map[string]string{"TEST": "100,10,99,20,101"}, false},
+        {"csv file", map[string]string{PriceSourceSetting: "File", PriceFile: filepath.Join(dir, "prices.csv")}, nil, true},
+        {"json file", map[string]string{PriceSourceSetting: "file", PriceFile: filepath.Join(dir, "prices.json")}, nil, true},
+        {"file of another kind", map[string]string{PriceSourceSetting: "file", PriceFile: filepath.Join(dir, "prices.txt")}, nil, false},
+        {"missing file", map[string]string{PriceSourceSetting: "file", PriceFile: filepath.Join(dir, "missing.csv")}, nil, false},
+        {"file without a PriceFile", map[string]string{PriceSourceSetting: "file"}, nil, false},
+        {"unknown source", map[string]string{PriceSourceSetting: "bloomberg"}, nil, false},
+    }
+
+    for _, test := range tests {
+        settings := quickfix.NewSessionSettings()
+        for setting, value := range test.settings {
+            settings.Set(setting, value)
+        }
+
+        source, err := newPriceSource(settings, test.section)
+        if !test.valid {
+            if err == nil {
+                t.Errorf("%v: accepted", test.name)
+            }
+            continue
+        }
+        if err != nil {
+            t.Errorf("%v: %v", test.name, err)
+            continue
+        }
+
+        if price, err := source.GetPrice("TEST"); err != nil || !price.Bid.Equals(decimal.New(99, 0)) || !price.AskSize.Equals(decimal.New(30, 0)) {
+            t.Errorf("%v: TEST is %+v, %v", test.name, price, err)
+        }
+        if _, err := source.GetPrice("UNKNOWN"); err != (UnknownSymbol{Symbol: "UNKNOWN"}) {
+            t.Errorf("%v: unknown symbol returned %v", test.name, err)
+        }
+    }
+
+    //the go-finance web service is the default, it is not called here
+    if source, err := newPriceSource(quickfix.NewSessionSettings(), nil); err != nil {
+        t.Errorf("default price source: %v", err)
+    } else if finance, ok := source.(*financeSource); !ok || finance.timeout != 2*time.Second {
+        t.Errorf("default price source %#v", source)
+    }
+
+    settings := quickfix.NewSessionSettings()
+    settings.Set(PriceTimeout, "soon")
+    if _, err := newPriceSource(settings, nil); err == nil {
+        t.Errorf("PriceTimeout of soon accepted")
+    }
+}
+
+func TestFinanceSource(t *testing.T) {
+    quote := &finance.Quote{LastTradePrice: decimal.New(100, 0), LastTradeSize: 10, Bid: decimal.New(99, 0), BidSize: 20, Ask: decimal.New(101, 0), AskSize: 30}
+
+    release := make(chan bool)
+    calls := make(map[string]int)
+    var lock sync.Mutex
+
+    source := newFinanceSource(20 * time.Millisecond)
+    source.getQuote = func(symbol string) (*finance.Quote, error) {
+        lock.Lock()
+        calls[symbol]++
+        lock.Unlock()
+
+        switch symbol {
+        case "TEST", "SLOW":
+            if symbol == "SLOW" {
+                <-release
+            }
+            return quote, nil
+        case "ZERO":
+            return &finance.Quote{}, nil
+        case "NIL":
+            return nil, nil
+        case "EMPTY":
+            //go-finance indexes the quotes of an empty answer
+            var quotes []*finance.Quote
+            return quotes[0], nil
+        }
+        return nil, fmt.Errorf("connection refused")
+    }
+
+    //answer is what GetPrice returns: a price, an unknown symbol or another error
+    tests := []struct {
+        symbol string
+        answer string
+        calls  int
+    }{
+        {"TEST", "price", 1},
+        {"TEST", "price", 1},
+        {"ZERO", "unknown", 1},
+        {"ZERO", "unknown", 1},
+        {"NIL", "unknown", 1},
+        {"EMPTY", "unknown", 1},
+        {"DOWN", "error", 1},
+        {"DOWN", "error", 2},
+        {"SLOW", "error", 1},
+    }
+
+    for _, test := range tests {
+        price, err := source.GetPrice(test.symbol)
+
+        answer := "error"
+        if _, unknown := err.(UnknownSymbol); unknown {
+            answer = "unknown"
+        } else if err == nil && price.Last.Equals(decimal.New(100, 0)) && price.AskSize.Equals(decimal.New(30, 0)) {
+            answer = "price"
+        }
+        if answer != test.answer {
+            t.Errorf("%v: %+v, %v, expected a %v", test.symbol, price, err, test.answer)
+        }
+
+        lock.Lock()
+        if calls[test.symbol] != test.calls {
+            t.Errorf("%v: looked up %v times, expected %v", test.symbol, calls[test.symbol], test.calls)
+        }
+        lock.Unlock()
+    }
+
+    //a quote answered after the timeout is there for the next order
+    close(release)
+    for i := 0; i < 100; i++ {
+        if _, err := source.GetPrice("SLOW"); err == nil {
+            return
+        }
+        time.Sleep(10 * time.Millisecond)
+    }
+    t.Errorf("SLOW never cached")
+}
+
+func TestSplitSection(t *testing.T) {
+    cfg := `[DEFAULT]
+SenderCompID=FIXIMULATOR
+PriceSource=static
+
+#prices of single symbols
+[prices]
+#a comment
+TEST=100,10,99,20,101,30
+OTHER = 50,1,49,2,51,3
+
+[SESSION]
+BeginString=FIX.4.2
+TargetCompID=CLIENT1
+
+[RISK]
+TEST.MaxOrderQty=50
+`
+
+    rest, prices, err := splitSection(strings.NewReader(cfg), pricesSection)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if expected := map[string]string{"TEST": "100,10,99,20,101,30", "OTHER": "50,1,49,2,51,3"}; !reflect.DeepEqual(prices, expected) {
+        t.Errorf("PRICES %v, expected %v", prices, expected)
+    }
+
+    rest, risk, err := splitSection(rest, riskSection)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if expected := map[string]string{"TEST.MaxOrderQty": "50"}; !reflect.DeepEqual(risk, expected) {
+        t.Errorf("RISK %v, expected %v", risk, expected)
+    }
+
+    //what is left is for quickfix
+    settings, err := quickfix.ParseSettings(rest)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(settings.SessionSettings()) != 1 {
+        t.Errorf("%v sessions, expected 1", len(settings.SessionSettings()))
+    }
+    if source, _ := settings.GlobalSettings().Setting(PriceSourceSetting); source != "static" {
+        t.Errorf("PriceSource %v, expected static", source)
+    }
+
+    //a section without Key=value lines is empty, a missing one too
+    if _, section, err := splitSection(strings.NewReader("[PHASES]\n#nothing\n"), phasesSection); err != nil || len(section) != 0 {
+        t.Errorf("empty section %v, %v", section, err)
+    }
+    if _, section, err := splitSection(strings.NewReader(cfg), marketMakerSection); err != nil || len(section) != 0 {
+        t.Errorf("missing section %v, %v", section, err)
+    }
+}
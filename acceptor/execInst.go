@@ -0,0 +1,36 @@
+package main
+
+import (
+    "strings"
+
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+)
+
+//hasExecInst reports whether inst is one of the space separated ExecInst of order
+func (o *Order) hasExecInst(inst enum.ExecInst) bool {
+    for _, i := range strings.Fields(string(o.ExecInst)) {
+        if enum.ExecInst(i) == inst {
+            return true
+        }
+    }
+    return false
+}
+
+//isAllOrNone reports whether order may only ever trade its whole LeavesQty at once, a fill or kill order
+//is an all or none order that does not rest
+func (o *Order) isAllOrNone() bool {
+    return o.hasExecInst(enum.ExecInst_ALL_OR_NONE) || o.TimeInForce == enum.TimeInForce_FILL_OR_KILL
+}
+
+//required returns the quantity order must be able to fill on arrival, and what it is refused with otherwise.
+//Orders without a MinQty that may rest require nothing.
+func (o *Order) required() (decimal.Decimal, string) {
+    switch {
+    case o.TimeInForce == enum.TimeInForce_FILL_OR_KILL:
+        return o.LeavesQty, "Fill or kill order cannot be filled entirely"
+    case o.isAllOrNone() && o.isImmediate():
+        return o.LeavesQty, "All or none order cannot be filled entirely"
+    }
+    return o.MinQty, "MinQty " + o.MinQty.String() + " cannot be filled"
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+
+    "github.com/btasdoven/quickfixwebclient/acceptor/orderbook"
+)
+
+//JournalPath is the file order events are appended to and replayed from on startup, no journal if not set
+const JournalPath string = "JournalPath"
+
+//kinds of journal events
+const (
+    eventNew     = "new"
+    eventReject  = "reject"
+    eventFill    = "fill"
+    eventTrade   = "trade"
+    eventRest    = "rest"
+    eventCancel  = "cancel"
+    eventReplace = "replace"
+    eventExpire  = "expire"
+    eventTrigger = "trigger"
+    eventRestate = "restate"
+    eventIDs     = "ids"
+)
+
+//journalEvent is one line of the journal. Order events carry the order as it stood after the event, trade
+//events carry one fill of the book, including fills against liquidity that is not journaled itself. Every
+//event carries the last OrderID and ExecID given so far, reports that are not journaled use ids too.
+type journalEvent struct {
+    Seq         uint64
+    Time        time.Time
+    Kind        string
+    Symbol      string          `json:",omitempty"`
+    Order       *Order          `json:",omitempty"`
+    Trade       *orderbook.Fill `json:",omitempty"`
+    LastOrderID int             `json:",omitempty"`
+    LastExecID  int             `json:",omitempty"`
+}
+
+//journal is an append-only log of every event that changed a client order or a book
+type journal struct {
+    file    *os.File
+    writer  *bufio.Writer
+    encoder *json.Encoder
+    seq     uint64
+}
+
+//openJournal reads back the events already in the journal at path and opens it for appending
+func openJournal(path string) (j *journal, events []journalEvent, err error) {
+    if events, err = readJournal(path); err != nil {
+        return
+    }
+
+    if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return
+    }
+
+    file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        return
+    }
+
+    j = &journal{file: file, writer: bufio.NewWriter(file)}
+    j.encoder = json.NewEncoder(j.writer)
+    if len(events) > 0 {
+        j.seq = events[len(events)-1].Seq
+    }
+    return
+}
+
+func readJournal(path string) (events []journalEvent, err error) {
+    file, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return
+    }
+    defer file.Close()
+
+    decoder := json.NewDecoder(file)
+    for decoder.More() {
+        var event journalEvent
+        if err = decoder.Decode(&event); err != nil {
+            return nil, fmt.Errorf("%v: event %v: %v", path, len(events)+1, err)
+        }
+        events = append(events, event)
+    }
+    return
+}
+
+//write appends event to the journal. Every event is flushed to the file before the reports it caused are
+//sent, so a restart never forgets what a client has been told.
+func (j *journal) write(event journalEvent) error {
+    j.seq++
+    event.Seq = j.seq
+    event.Time = time.Now()
+
+    if err := j.encoder.Encode(event); err != nil {
+        return err
+    }
+    return j.writer.Flush()
+}
+
+func (j *journal) Close() error {
+    if err := j.writer.Flush(); err != nil {
+        return err
+    }
+    return j.file.Close()
+}
+
+//write journals event with the OrderID and ExecID counters as they stand
+func (e *executor) write(event journalEvent) error {
+    event.LastOrderID, event.LastExecID = e.orderID, e.execID
+    return e.journal.write(event)
+}
+
+//record journals order as it stands after an event of kind, orders of the market makers are not journaled
+func (e *executor) record(kind string, order *Order) {
+    if e.journal == nil || order.isSynthetic() {
+        return
+    }
+
+    snapshot := *order
+    if err := e.write(journalEvent{Kind: kind, Order: &snapshot}); err != nil {
+        e.printf("[SERVER]: Unable to journal %v of %v: %v\n", kind, order.ClOrdID, err)
+    }
+}
+
+//recordTrade journals one fill of the book of stock
+func (e *executor) recordTrade(stock *Quote, fill orderbook.Fill) {
+    if e.journal == nil {
+        return
+    }
+
+    if err := e.write(journalEvent{Kind: eventTrade, Symbol: stock.symbol, Trade: &fill}); err != nil {
+        e.printf("[SERVER]: Unable to journal trade of %v: %v\n", fill.Aggressor, err)
+    }
+}
+
+//recordIDs journals the id counters before a report that is not journaled itself is sent, such as a status
+//reply, so that a restart never gives its ExecID again
+func (e *executor) recordIDs() {
+    if e.journal == nil {
+        return
+    }
+
+    if err := e.write(journalEvent{Kind: eventIDs}); err != nil {
+        e.printf("[SERVER]: Unable to journal ExecID %v: %v\n", e.execID, err)
+    }
+}
+
+//keepsPriority reports whether amending order to its current Price and LeavesQty leaves it where it is in
+//the queue, see orderbook.Book.Amend
+func (q *Quote) keepsPriority(order *Order) bool {
+    resting, ok := q.book.Get(order.OrderID)
+    return ok && resting.Price.Equals(order.bookPrice()) && order.LeavesQty.Cmp(resting.Quantity) <= 0
+}
+
+//replay rebuilds the orders and books from the events of a journal. The books are seeded exactly as on the
+//first run and every rest, trade, cancel and amend is applied again in order, so the queues come back in
+//the same priority. Market makers are started once the books are rebuilt and back in their trading phase.
+func (e *executor) replay(events []journalEvent) error {
+    e.replaying = true
+    for _, event := range events {
+        if err := e.apply(event); err != nil {
+            e.replaying = false
+            return fmt.Errorf("journal event %v: %v", event.Seq, err)
+        }
+    }
+    e.replaying = false
+
+    for _, stock := range e.quotes {
+        e.setPhase(stock, stock.schedule.at(e.now()))
+        if err := e.startMarketMaker(stock); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+//apply replays one journal event
+func (e *executor) apply(event journalEvent) error {
+    e.restoreIDs(event)
+
+    if event.Kind == eventIDs {
+        return nil
+    }
+
+    if event.Kind == eventTrade {
+        stock, err := e.getQuote(event.Symbol)
+        if err != nil {
+            return err
+        }
+
+        //the aggressor has not rested yet, only the resting side of the fill is in the book
+        fill := *event.Trade
+        if fill.Quantity.Cmp(decimal.Zero) > 0 {
+            stock.book.Reduce(fill.Resting, fill.Quantity)
+        }
+        //both orders of an auction were resting
+        if fill.Auction {
+            stock.book.Reduce(fill.Aggressor, fill.Quantity)
+        }
+        if !fill.SelfTrade {
+            stock.trade = mdLevel{price: fill.Price, size: fill.Quantity}
+        }
+        return nil
+    }
+
+    if event.Order == nil {
+        return fmt.Errorf("%v event without an order", event.Kind)
+    }
+
+    order, ok := e.orderIDs[event.Order.OrderID]
+    if ok {
+        *order = *event.Order
+    } else {
+        order = event.Order
+        e.orders = append(e.orders, order)
+    }
+    e.index(order)
+
+    if event.Kind == eventReject {
+        return nil
+    }
+
+    stock, err := e.getQuote(order.Symbol)
+    if err != nil {
+        return err
+    }
+
+    switch event.Kind {
+    case eventNew:
+        if order.isStop() {
+            e.stops[order.Symbol] = append(e.stops[order.Symbol], order)
+        }
+        if order.isPegged() {
+            e.pegs[order.Symbol] = append(e.pegs[order.Symbol], order)
+        }
+        if order.TimeInForce == enum.TimeInForce_AT_THE_CLOSE {
+            e.closeOrders[order.Symbol] = append(e.closeOrders[order.Symbol], order)
+        }
+
+    case eventTrigger:
+        e.removeStop(order)
+
+    case eventRest:
+        //MinQty only applies on arrival, the order was matched when it came
+        resting := e.bookOrderOf(order)
+        resting.MinQuantity = decimal.Zero
+        if _, err := stock.book.Submit(resting); err != nil {
+            return err
+        }
+        e.resting[order.OrderID] = order
+
+    case eventReplace, eventRestate:
+        if _, ok := e.resting[order.OrderID]; !ok {
+            break
+        }
+        //an amend, or a peg that moved, that lost its priority is journaled as a rest once it has been matched again
+        if stock.keepsPriority(order) {
+            stock.book.Amend(order.OrderID, order.bookPrice(), order.LeavesQty)
+        } else {
+            e.unbook(order)
+        }
+
+    case eventCancel, eventExpire:
+        if !e.removeStop(order) {
+            e.unbook(order)
+        }
+
+    case eventFill:
+        if order.OrderStatus == enum.OrdStatus_FILLED {
+            delete(e.resting, order.OrderID)
+        }
+    }
+    return nil
+}
+
+//restoreIDs moves the OrderID and ExecID counters past the ids given before event, or past those of its
+//order in journals written without the counters
+func (e *executor) restoreIDs(event journalEvent) {
+    orderID, execID := event.LastOrderID, event.LastExecID
+    if event.Order != nil {
+        if id, err := strconv.Atoi(event.Order.OrderID); err == nil && id > orderID {
+            orderID = id
+        }
+        if id, err := strconv.Atoi(event.Order.ExecID); err == nil && id > execID {
+            execID = id
+        }
+    }
+
+    if orderID > e.orderID {
+        e.orderID = orderID
+    }
+    if execID > e.execID {
+        e.execID = execID
+    }
+}
+
+//restore opens the journal configured in settings, if any, and replays it into e
+func (e *executor) restore(settings *quickfix.SessionSettings) error {
+    if !settings.HasSetting(JournalPath) {
+        return nil
+    }
+
+    path, err := settings.Setting(JournalPath)
+    if err != nil {
+        return err
+    }
+
+    j, events, err := openJournal(path)
+    if err != nil {
+        return err
+    }
+
+    e.lock.Lock()
+    defer e.lock.Unlock()
+
+    if err = e.replay(events); err != nil {
+        j.Close()
+        return err
+    }
+
+    e.printf("[SERVER]: Replayed %v events of %v, %v orders\n", len(events), path, len(e.orders))
+    e.journal = j
+    return nil
+}
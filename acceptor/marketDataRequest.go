@@ -0,0 +1,308 @@
+package main
+
+import (
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/quickfixgo/quickfix/field"
+    "github.com/quickfixgo/quickfix/fix42"
+    "github.com/quickfixgo/quickfix/tag"
+    "github.com/shopspring/decimal"
+
+    fix42mdr "github.com/quickfixgo/quickfix/fix42/marketdatarequest"
+    fix42md "github.com/quickfixgo/quickfix/fix42/marketdatasnapshotfullrefresh"
+
+    "github.com/btasdoven/quickfixwebclient/acceptor/orderbook"
+)
+
+//mdLevel is a price and size published for one side of the book
+type mdLevel struct {
+    price decimal.Decimal
+    size  decimal.Decimal
+}
+
+type subscriptionKey struct {
+    sessionID quickfix.SessionID
+    mdReqID   string
+}
+
+//published is what a subscriber was last sent for one symbol
+type published struct {
+    levels  map[enum.MDEntryType][]mdLevel
+    printed uint64
+}
+
+//subscription is a SNAPSHOT_PLUS_UPDATES request, it remembers what was last sent so that only changes are published
+type subscription struct {
+    subscriptionKey
+    entryTypes []enum.MDEntryType
+    depth      int
+
+    symbols map[string]*published
+}
+
+//levels returns up to depth price levels of one side of stock with the size resting at each price
+//aggregated, a depth of 0 returns the full book. The opening and closing price are a single level, see auction.
+func (q *Quote) levels(entryType enum.MDEntryType, depth int) []mdLevel {
+    if entryType == enum.MDEntryType_OPENING_PRICE || entryType == enum.MDEntryType_CLOSING_PRICE {
+        return q.auction(entryType)
+    }
+
+    side := orderbook.Buy
+    if entryType == enum.MDEntryType_OFFER {
+        side = orderbook.Sell
+    }
+
+    var levels []mdLevel
+    for _, level := range q.book.Depth(side, depth) {
+        levels = append(levels, mdLevel{price: level.Price, size: level.Quantity})
+    }
+    return levels
+}
+
+func newMarketDataRequestReject(mdReqID string, reason enum.MDReqRejReason, text string) *quickfix.Message {
+    msg := quickfix.NewMessage()
+    header := fix42.NewHeader(&msg.Header)
+    header.SetMsgType(enum.MsgType_MARKET_DATA_REQUEST_REJECT)
+
+    msg.Body.Set(field.NewMDReqID(mdReqID))
+    msg.Body.Set(field.NewMDReqRejReason(reason))
+    msg.Body.Set(field.NewText(text))
+
+    return msg
+}
+
+//newMarketDataIncrementalRefresh builds a fix42 MarketDataIncrementalRefresh, MsgType = X, carrying entries
+func newMarketDataIncrementalRefresh(mdReqID string, entries *quickfix.RepeatingGroup) *quickfix.Message {
+    msg := quickfix.NewMessage()
+    header := fix42.NewHeader(&msg.Header)
+    header.SetMsgType(enum.MsgType_MARKET_DATA_INCREMENTAL_REFRESH)
+
+    msg.Body.Set(field.NewMDReqID(mdReqID))
+    msg.Body.SetGroup(entries)
+
+    return msg
+}
+
+func newIncrementalEntries() *quickfix.RepeatingGroup {
+    return quickfix.NewRepeatingGroup(tag.NoMDEntries, quickfix.GroupTemplate{
+        quickfix.GroupElement(tag.MDUpdateAction),
+        quickfix.GroupElement(tag.MDEntryType),
+        quickfix.GroupElement(tag.Symbol),
+        quickfix.GroupElement(tag.MDEntryPx),
+        quickfix.GroupElement(tag.MDEntrySize),
+        quickfix.GroupElement(tag.MDEntryPositionNo),
+    })
+}
+
+//addIncrementalEntry adds an update to entries, position is the 1-based book level or 0 for trades
+func addIncrementalEntry(entries *quickfix.RepeatingGroup, action enum.MDUpdateAction, entryType enum.MDEntryType, symbol string, level mdLevel, position int) {
+    entry := entries.Add()
+    entry.Set(field.NewMDUpdateAction(action))
+    entry.Set(field.NewMDEntryType(entryType))
+    entry.Set(field.NewSymbol(symbol))
+    entry.Set(field.NewMDEntryPx(level.price, 5))
+    entry.Set(field.NewMDEntrySize(level.size, 5))
+    if position > 0 {
+        entry.Set(field.NewMDEntryPositionNo(position))
+    }
+}
+
+//snapshot builds the full refresh of stock for the requested entry types
+func snapshot(stock *Quote, mdReqID string, entryTypes []enum.MDEntryType, depth int) fix42md.MarketDataSnapshotFullRefresh {
+    noMDEntries := fix42md.NewNoMDEntriesRepeatingGroup()
+
+    for _, entryType := range entryTypes {
+        switch entryType {
+        case enum.MDEntryType_BID, enum.MDEntryType_OFFER, enum.MDEntryType_OPENING_PRICE, enum.MDEntryType_CLOSING_PRICE:
+            for i, level := range stock.levels(entryType, depth) {
+                entry := noMDEntries.Add()
+                entry.SetMDEntryType(entryType)
+                entry.SetMDEntryPx(level.price, 5)
+                entry.SetMDEntrySize(level.size, 5)
+                entry.SetMDEntryPositionNo(i + 1)
+            }
+        case enum.MDEntryType_TRADE:
+            entry := noMDEntries.Add()
+            entry.SetMDEntryType(entryType)
+            entry.SetMDEntryPx(stock.trade.price, 5)
+            entry.SetMDEntrySize(stock.trade.size, 5)
+        }
+    }
+
+    md := fix42md.New(field.NewSymbol(stock.symbol))
+    md.SetMDReqID(mdReqID)
+    md.SetNoMDEntries(noMDEntries)
+
+    return md
+}
+
+func (e *executor) OnFIX42MarketDataRequest(msg fix42mdr.MarketDataRequest, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+    e.printf("[SERVER] - MDR: %+v\n", msg.Message)
+
+    mdReqID, reject := msg.GetMDReqID()
+    if reject != nil {
+        return
+    }
+
+    subscriptionType, reject := msg.GetSubscriptionRequestType()
+    if reject != nil {
+        return
+    }
+
+    key := subscriptionKey{sessionID: sessionID, mdReqID: mdReqID}
+
+    switch subscriptionType {
+    case enum.SubscriptionRequestType_DISABLE_PREVIOUS_SNAPSHOT_PLUS_UPDATE_REQUEST:
+        e.printf("\tUnsubscribing %v\n", mdReqID)
+        delete(e.subscriptions, key)
+        return
+    case enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES:
+        if _, ok := e.subscriptions[key]; ok {
+            e.send(newMarketDataRequestReject(mdReqID, enum.MDReqRejReason_DUPLICATE_MDREQID, "Duplicate MDReqID"), sessionID)
+            return
+        }
+    case enum.SubscriptionRequestType_SNAPSHOT:
+    default:
+        e.send(newMarketDataRequestReject(mdReqID, enum.MDReqRejReason_UNSUPPORTED_SUBSCRIPTIONREQUESTTYPE, "Unsupported SubscriptionRequestType"), sessionID)
+        return
+    }
+
+    depth, reject := msg.GetMarketDepth()
+    if reject != nil {
+        return
+    }
+
+    noRelatedSym, _ := msg.GetNoRelatedSym()
+
+    //a request is answered for all of its symbols or rejected as a whole
+    stocks := make([]*Quote, 0, noRelatedSym.Len())
+    for i := 0; i < noRelatedSym.Len(); i++ {
+        symbol, _ := noRelatedSym.Get(i).GetSymbol()
+        e.printf("\tSymbol: %+v\n", symbol)
+
+        stock, err := e.getQuote(symbol)
+        if err != nil {
+            e.send(newMarketDataRequestReject(mdReqID, enum.MDReqRejReason_UNKNOWN_SYMBOL, "Unknown symbol "+symbol), sessionID)
+            return
+        }
+        stocks = append(stocks, stock)
+    }
+
+    if len(stocks) == 0 {
+        return
+    }
+
+    noMDEntryTypes, _ := msg.GetNoMDEntryTypes()
+    entryTypes := make([]enum.MDEntryType, 0, noMDEntryTypes.Len())
+
+    for i := 0; i < noMDEntryTypes.Len(); i++ {
+        entryType, _ := noMDEntryTypes.Get(i).GetMDEntryType()
+        entryTypes = append(entryTypes, entryType)
+    }
+
+    for _, stock := range stocks {
+        md := snapshot(stock, mdReqID, entryTypes, depth)
+
+        e.printf("\tSending %+v", md)
+        e.send(md, sessionID)
+    }
+
+    if subscriptionType == enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES {
+        sub := &subscription{
+            subscriptionKey: key,
+            entryTypes:      entryTypes,
+            depth:           depth,
+            symbols:         make(map[string]*published),
+        }
+
+        for _, stock := range stocks {
+            _, printed := stock.book.TradesSince(0)
+            pub := &published{levels: make(map[enum.MDEntryType][]mdLevel), printed: printed}
+            for _, entryType := range entryTypes {
+                pub.levels[entryType] = stock.levels(entryType, depth)
+            }
+            sub.symbols[stock.symbol] = pub
+        }
+
+        e.subscriptions[key] = sub
+    }
+
+    e.DumpOrders()
+
+    return
+}
+
+//unsubscribeAll drops every subscription of sessionID
+func (e *executor) unsubscribeAll(sessionID quickfix.SessionID) {
+    for key := range e.subscriptions {
+        if key.sessionID == sessionID {
+            delete(e.subscriptions, key)
+        }
+    }
+}
+
+//addLevelChanges appends to entries the updates taking a subscriber from the previous levels of one side to
+//the current ones. Levels are matched by price: prices gone are deleted bottom up, then prices that appeared
+//are added and sizes that moved are changed top down, so that every position is right when it is applied.
+func addLevelChanges(entries *quickfix.RepeatingGroup, entryType enum.MDEntryType, symbol string, previous []mdLevel, current []mdLevel) {
+    previousSizes, currentSizes := sizeByPrice(previous), sizeByPrice(current)
+
+    for i := len(previous) - 1; i >= 0; i-- {
+        if _, ok := currentSizes[previous[i].price.String()]; !ok {
+            addIncrementalEntry(entries, enum.MDUpdateAction_DELETE, entryType, symbol, previous[i], i+1)
+        }
+    }
+
+    for i, level := range current {
+        size, ok := previousSizes[level.price.String()]
+        switch {
+        case !ok:
+            addIncrementalEntry(entries, enum.MDUpdateAction_NEW, entryType, symbol, level, i+1)
+        case !size.Equals(level.size):
+            addIncrementalEntry(entries, enum.MDUpdateAction_CHANGE, entryType, symbol, level, i+1)
+        }
+    }
+}
+
+//sizeByPrice indexes the sizes of levels by their price
+func sizeByPrice(levels []mdLevel) map[string]decimal.Decimal {
+    sizes := make(map[string]decimal.Decimal, len(levels))
+    for _, level := range levels {
+        sizes[level.price.String()] = level.size
+    }
+    return sizes
+}
+
+//publish appends to entries whatever changed in stock since it was last published to sub
+func (e *executor) publish(sub *subscription, pub *published, stock *Quote, entries *quickfix.RepeatingGroup) {
+    trades, printed := stock.book.TradesSince(pub.printed)
+
+    for _, entryType := range sub.entryTypes {
+        switch entryType {
+        case enum.MDEntryType_BID, enum.MDEntryType_OFFER, enum.MDEntryType_OPENING_PRICE, enum.MDEntryType_CLOSING_PRICE:
+            current := stock.levels(entryType, sub.depth)
+            addLevelChanges(entries, entryType, stock.symbol, pub.levels[entryType], current)
+            pub.levels[entryType] = current
+        case enum.MDEntryType_TRADE:
+            for _, trade := range trades {
+                addIncrementalEntry(entries, enum.MDUpdateAction_NEW, entryType, stock.symbol, mdLevel{price: trade.Price, size: trade.Quantity}, 0)
+            }
+        }
+    }
+
+    pub.printed = printed
+}
+
+//publishMarketData sends every subscriber the changes to the books and trades it subscribed to
+func (e *executor) publishMarketData() {
+    for _, sub := range e.subscriptions {
+        entries := newIncrementalEntries()
+        for symbol, pub := range sub.symbols {
+            e.publish(sub, pub, e.quotes[symbol], entries)
+        }
+
+        if entries.Len() > 0 {
+            e.send(newMarketDataIncrementalRefresh(sub.mdReqID, entries), sub.sessionID)
+        }
+    }
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+    "fmt"
+    "hash/fnv"
+    "math"
+    "math/rand"
+    "strings"
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+)
+
+const (
+    //MarketMaker enables a simulated liquidity provider on every symbol, N if not set. Every MarketMaker
+    //setting may also be set for single symbols in the MARKETMAKER section.
+    MarketMaker string = "MarketMaker"
+    //MarketMakerModel is how the mid moves: walk for an arithmetic random walk or gbm for a geometric brownian motion
+    MarketMakerModel string = "MarketMakerModel"
+    //MarketMakerSeed seeds the price paths so that runs can be repeated
+    MarketMakerSeed string = "MarketMakerSeed"
+    //MarketMakerVolatility is the standard deviation of a walk step in price units, or the annual volatility of the gbm
+    MarketMakerVolatility string = "MarketMakerVolatility"
+    //MarketMakerDrift is the annual drift of the gbm
+    MarketMakerDrift string = "MarketMakerDrift"
+    //MarketMakerLevels is the number of price levels quoted on each side
+    MarketMakerLevels string = "MarketMakerLevels"
+    //MarketMakerSpread is the distance between the best bid and the best ask
+    MarketMakerSpread string = "MarketMakerSpread"
+    //MarketMakerTick is the distance between two levels of the ladder
+    MarketMakerTick string = "MarketMakerTick"
+    //MarketMakerSize is the quantity quoted at each level
+    MarketMakerSize string = "MarketMakerSize"
+    //MarketMakerInterval is how often the mid moves and the ladder is refreshed, e.g. 500ms
+    MarketMakerInterval string = "MarketMakerInterval"
+)
+
+//tradingYear is the length of a year of trading hours, the unit of the gbm volatility and drift
+const tradingYear = 252 * 6.5 * float64(time.Hour)
+
+//marketMakerConfig is how a simulated market maker quotes
+type marketMakerConfig struct {
+    model      string
+    seed       int64
+    volatility float64
+    drift      float64
+    levels     int
+    spread     decimal.Decimal
+    tick       decimal.Decimal
+    size       decimal.Decimal
+    interval   time.Duration
+}
+
+//marketMaker quotes a ladder of synthetic orders around a moving mid on one symbol
+type marketMaker struct {
+    *marketMakerConfig
+    stock  *Quote
+    mid    float64
+    random *rand.Rand
+    orders []*Order
+    quotes int
+
+    //due is when the mid next moves, it is only used by replays
+    due time.Time
+}
+
+func floatSetting(settings *quickfix.SessionSettings, setting string, val *float64) (err error) {
+    if settings == nil || !settings.HasSetting(setting) {
+        return
+    }
+
+    value, _ := settings.Setting(setting)
+    d, err := decimal.NewFromString(value)
+    if err != nil {
+        return quickfix.IncorrectFormatForSetting{Setting: setting, Value: value, Err: err}
+    }
+    *val, _ = d.Float64()
+    return
+}
+
+func decimalSetting(settings *quickfix.SessionSettings, setting string, val *decimal.Decimal) (err error) {
+    if settings == nil || !settings.HasSetting(setting) {
+        return
+    }
+
+    value, _ := settings.Setting(setting)
+    if *val, err = decimal.NewFromString(value); err != nil {
+        return quickfix.IncorrectFormatForSetting{Setting: setting, Value: value, Err: err}
+    }
+    return
+}
+
+//marketMakerSection is the acceptor.cfg section holding the market makers of single symbols, as Symbol.Setting=value
+const marketMakerSection = "MARKETMAKER"
+
+//newMarketMakerConfig reads the MarketMaker settings, every setting is taken from the first of layers holding
+//it. It returns nil if there is no market maker.
+func newMarketMakerConfig(layers ...*quickfix.SessionSettings) (c *marketMakerConfig, err error) {
+    lookup := func(setting string) *quickfix.SessionSettings {
+        for _, settings := range layers {
+            if settings != nil && settings.HasSetting(setting) {
+                return settings
+            }
+        }
+        return nil
+    }
+
+    settings := lookup(MarketMaker)
+    if settings == nil {
+        return
+    }
+
+    enabled, err := settings.BoolSetting(MarketMaker)
+    if err != nil || !enabled {
+        return
+    }
+
+    c = &marketMakerConfig{
+        model:      "walk",
+        seed:       1,
+        volatility: 0.05,
+        levels:     5,
+        spread:     decimal.New(2, -2),
+        tick:       decimal.New(1, -2),
+        size:       decimal.New(100, 0),
+        interval:   time.Second,
+    }
+
+    if settings = lookup(MarketMakerModel); settings != nil {
+        c.model, _ = settings.Setting(MarketMakerModel)
+        c.model = strings.ToLower(c.model)
+        if c.model != "walk" && c.model != "gbm" {
+            return nil, quickfix.IncorrectFormatForSetting{Setting: MarketMakerModel, Value: c.model, Err: fmt.Errorf("expected walk or gbm")}
+        }
+    }
+
+    if settings = lookup(MarketMakerSeed); settings != nil {
+        var seed int
+        if seed, err = settings.IntSetting(MarketMakerSeed); err != nil {
+            return nil, err
+        }
+        c.seed = int64(seed)
+    }
+
+    if settings = lookup(MarketMakerLevels); settings != nil {
+        if c.levels, err = settings.IntSetting(MarketMakerLevels); err != nil {
+            return nil, err
+        }
+    }
+
+    if settings = lookup(MarketMakerInterval); settings != nil {
+        value, _ := settings.Setting(MarketMakerInterval)
+        if c.interval, err = time.ParseDuration(value); err != nil {
+            return nil, quickfix.IncorrectFormatForSetting{Setting: MarketMakerInterval, Value: value, Err: err}
+        }
+    }
+
+    if err = floatSetting(lookup(MarketMakerVolatility), MarketMakerVolatility, &c.volatility); err != nil {
+        return nil, err
+    }
+    if err = floatSetting(lookup(MarketMakerDrift), MarketMakerDrift, &c.drift); err != nil {
+        return nil, err
+    }
+    if err = decimalSetting(lookup(MarketMakerSpread), MarketMakerSpread, &c.spread); err != nil {
+        return nil, err
+    }
+    if err = decimalSetting(lookup(MarketMakerTick), MarketMakerTick, &c.tick); err != nil {
+        return nil, err
+    }
+    if err = decimalSetting(lookup(MarketMakerSize), MarketMakerSize, &c.size); err != nil {
+        return nil, err
+    }
+
+    if c.tick.Cmp(decimal.Zero) <= 0 {
+        return nil, quickfix.IncorrectFormatForSetting{Setting: MarketMakerTick, Value: c.tick.String(), Err: fmt.Errorf("expected a positive tick")}
+    }
+    return
+}
+
+//marketMakerRules are the market makers of the symbols, those of the DEFAULT settings unless a symbol has its own
+type marketMakerRules struct {
+    defaults *marketMakerConfig
+    symbols  map[string]*marketMakerConfig
+}
+
+//newMarketMakerRules reads the market makers of the DEFAULT settings and of the symbols of the market maker
+//section, a symbol takes what it does not set from the DEFAULT settings
+func newMarketMakerRules(defaults *quickfix.SessionSettings, symbols map[string]string) (r *marketMakerRules, err error) {
+    r = &marketMakerRules{symbols: make(map[string]*marketMakerConfig)}
+
+    if r.defaults, err = newMarketMakerConfig(defaults); err != nil {
+        return nil, err
+    }
+
+    bySymbol, err := symbolSettings(marketMakerSection, symbols)
+    if err != nil {
+        return nil, err
+    }
+
+    for symbol, settings := range bySymbol {
+        if r.symbols[symbol], err = newMarketMakerConfig(settings, defaults); err != nil {
+            return nil, fmt.Errorf("[%v] %v: %v", marketMakerSection, symbol, err)
+        }
+    }
+    return
+}
+
+//of returns how the market maker of symbol quotes, nil if symbol has none
+func (r *marketMakerRules) of(symbol string) *marketMakerConfig {
+    if r == nil {
+        return nil
+    }
+    if c, ok := r.symbols[symbol]; ok {
+        return c
+    }
+    return r.defaults
+}
+
+//newMarketMaker starts quoting stock around mid, every symbol gets its own path derived from the seed
+func newMarketMaker(c *marketMakerConfig, stock *Quote, mid decimal.Decimal) *marketMaker {
+    h := fnv.New64a()
+    h.Write([]byte(stock.symbol))
+
+    m := &marketMaker{
+        marketMakerConfig: c,
+        stock:             stock,
+        random:            rand.New(rand.NewSource(c.seed ^ int64(h.Sum64()))),
+    }
+    m.mid, _ = mid.Float64()
+    return m
+}
+
+//step moves the mid one interval along the configured price path
+func (m *marketMaker) step() {
+    z := m.random.NormFloat64()
+
+    switch m.model {
+    case "gbm":
+        dt := float64(m.interval) / tradingYear
+        m.mid *= math.Exp((m.drift-m.volatility*m.volatility/2)*dt + m.volatility*math.Sqrt(dt)*z)
+    default:
+        m.mid += m.volatility * z
+    }
+
+    if tick, _ := m.tick.Float64(); m.mid < tick {
+        m.mid = tick
+    }
+}
+
+//newQuote builds one synthetic GTC limit order of the ladder
+func (m *marketMaker) newQuote(side enum.Side, price decimal.Decimal) *Order {
+    m.quotes++
+    return &Order{
+        ClOrdID:     fmt.Sprintf("MM-%v-%v", m.stock.symbol, m.quotes),
+        OrdType:     enum.OrdType_LIMIT,
+        Side:        side,
+        Symbol:      m.stock.symbol,
+        TimeInForce: enum.TimeInForce_GOOD_TILL_CANCEL,
+        OrderStatus: enum.OrdStatus_NEW,
+        Price:       price,
+        OrderQty:    m.size,
+        LeavesQty:   m.size,
+        TotalPrice:  decimal.Zero,
+    }
+}
+
+//refresh pulls the previous ladder and quotes a new one around the current mid, quotes crossing resting
+//client orders trade with them
+func (e *executor) refresh(m *marketMaker) {
+    for _, order := range m.orders {
+        if order.isWorking() {
+            e.unbook(order)
+        }
+    }
+    m.orders = m.orders[:0]
+
+    //the mid is quoted on the tick of the ladder
+    half := m.spread.Div(decimal.New(2, 0))
+    mid := decimal.NewFromFloat(m.mid).Div(m.tick).Round(0).Mul(m.tick)
+
+    for i := 0; i < m.levels; i++ {
+        offset := half.Add(m.tick.Mul(decimal.New(int64(i), 0)))
+
+        if bid := mid.Sub(offset); bid.Cmp(decimal.Zero) > 0 {
+            m.orders = append(m.orders, m.newQuote(enum.Side_BUY, bid))
+        }
+        m.orders = append(m.orders, m.newQuote(enum.Side_SELL, mid.Add(offset)))
+    }
+
+    for _, order := range m.orders {
+        order.OrderID = e.genOrderID().Value()
+        e.execute(m.stock, order)
+    }
+
+    e.triggerStops(m.stock)
+    e.repeg(m.stock)
+}
+
+//run moves the market every interval, it never returns
+func (e *executor) run(m *marketMaker) {
+    for range time.Tick(m.interval) {
+        e.lock.Lock()
+        m.step()
+        e.refresh(m)
+        e.publishMarketData()
+        e.lock.Unlock()
+    }
+}
+
+//isSynthetic reports whether order belongs to a simulated market maker rather than a client session
+func (o *Order) isSynthetic() bool {
+    return o.SessionID == quickfix.SessionID{}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/quickfixgo/quickfix/field"
+    "github.com/quickfixgo/quickfix/tag"
+    "github.com/shopspring/decimal"
+)
+
+//rejectReplace answers a cancel/replace request for a working order that cannot be applied as asked, the
+//order is left as it was
+func (e *executor) rejectReplace(order *Order, clOrdID string, origClOrdID string, text string, sessionID quickfix.SessionID) {
+    e.printf("[SERVER]: Rejecting replace %v for %v: %v\n", clOrdID, origClOrdID, text)
+    e.send(newOrderCancelReject(
+        order.OrderID,
+        clOrdID,
+        origClOrdID,
+        order.OrderStatus,
+        enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST,
+        enum.CxlRejReason_OTHER,
+        text), sessionID)
+}
+
+func (e *executor) OnFIX42OrderCancelReplaceRequest(msg *quickfix.Message, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
+    origClOrdID, err := msg.Body.GetString(tag.OrigClOrdID)
+    if err != nil {
+        return
+    }
+
+    clOrdID, err := msg.Body.GetString(tag.ClOrdID)
+    if err != nil {
+        return
+    }
+
+    ordType, err := msg.Body.GetString(tag.OrdType)
+    if err != nil {
+        return
+    }
+
+    side, err := msg.Body.GetString(tag.Side)
+    if err != nil {
+        return
+    }
+
+    var orderQty field.OrderQtyField
+    if err = msg.Body.Get(&orderQty); err != nil {
+        return
+    }
+
+    e.printf("[SERVER]: OrderCancelReplaceRequest %v for %v\n", clOrdID, origClOrdID)
+
+    order := e.findOrder(sessionID, origClOrdID)
+    if order == nil || !order.isWorking() {
+        e.rejectCancel(order, clOrdID, origClOrdID, enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, sessionID)
+        return
+    }
+
+    if e.findOrder(sessionID, clOrdID) != nil {
+        e.rejectDuplicate(order, clOrdID, origClOrdID, enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, sessionID)
+        return
+    }
+
+    //neither the order type nor the side can be amended, a triggered stop is replaced as the stop it was sent as
+    if enum.OrdType(ordType) != order.clientOrdType() {
+        e.rejectReplace(order, clOrdID, origClOrdID, "OrdType cannot be changed", sessionID)
+        return
+    }
+    if enum.Side(side) != order.Side {
+        e.rejectReplace(order, clOrdID, origClOrdID, "Side cannot be changed", sessionID)
+        return
+    }
+
+    var price field.PriceField
+    if order.OrdType == enum.OrdType_LIMIT || order.OrdType == enum.OrdType_STOP_LIMIT {
+        if err = msg.Body.Get(&price); err != nil {
+            return
+        }
+    }
+
+    //a pegged order may be replaced with a new limit, or none
+    if order.isPegged() && msg.Body.Has(tag.Price) {
+        if err = msg.Body.Get(&price); err != nil {
+            return
+        }
+    }
+
+    var stopPx field.StopPxField
+    if order.isStop() {
+        if err = msg.Body.Get(&stopPx); err != nil {
+            return
+        }
+    }
+
+    if orderQty.Value().Cmp(order.CumQty) <= 0 {
+        e.rejectReplace(order, clOrdID, origClOrdID, "OrderQty must exceed the executed quantity", sessionID)
+        return
+    }
+
+    stock := e.quotes[order.Symbol]
+
+    replaced := *order
+    replaced.OrderQty = orderQty.Value()
+    replaced.LeavesQty = replaced.OrderQty.Sub(replaced.CumQty)
+    replaced.Price = price.Value()
+    if order.isStop() {
+        replaced.StopPx = stopPx.Value()
+    }
+    if order.isPegged() {
+        replaced.PeggedPrice, _ = stock.pegPrice(&replaced)
+    }
+    if text := e.checkRisk(stock, &replaced, order); text != "" {
+        e.rejectReplace(order, clOrdID, origClOrdID, text, sessionID)
+        return
+    }
+
+    order.OrigClOrdID = order.ClOrdID
+    order.ClOrdID = clOrdID
+    e.index(order)
+    order.OrderQty = orderQty.Value()
+    order.LeavesQty = order.OrderQty.Sub(order.CumQty)
+    order.Price = price.Value()
+
+    order.LastPrice = decimal.Zero
+    order.LastShares = decimal.Zero
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_REPLACED
+    order.ExecID = e.genExecID().Value()
+
+    if order.isStop() {
+        order.StopPx = stopPx.Value()
+    }
+
+    //stops, pegs with nothing to follow and orders waiting for the closing call are not in the book yet
+    if _, booked := e.resting[order.OrderID]; !booked {
+        e.record(eventReplace, order)
+        e.send(newExecutionReport(order), sessionID)
+
+        e.triggerStops(stock)
+        e.repeg(stock)
+
+        e.DumpOrders()
+        return
+    }
+
+    e.record(eventReplace, order)
+
+    execReport := newExecutionReport(order)
+    execReport.SetPrice(order.bookPrice(), 2)
+    e.send(execReport, sessionID)
+
+    //the book keeps the time priority of an order reduced at an unchanged price, anything else is matched again
+    keepsPriority := stock.keepsPriority(order)
+    fills, _ := stock.book.Amend(order.OrderID, order.bookPrice(), order.LeavesQty)
+    e.report(stock, order, fills)
+
+    if !order.isWorking() {
+        delete(e.resting, order.OrderID)
+    } else if !keepsPriority {
+        e.record(eventRest, order)
+    }
+
+    e.triggerStops(stock)
+    e.repeg(stock)
+
+    e.DumpOrders()
+    return
+}
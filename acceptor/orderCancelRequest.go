@@ -0,0 +1,107 @@
+package main
+
+import (
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/quickfixgo/quickfix/field"
+    "github.com/quickfixgo/quickfix/fix42"
+    "github.com/quickfixgo/quickfix/tag"
+)
+
+//newOrderCancelReject builds a fix42 OrderCancelReject, MsgType = 9
+func newOrderCancelReject(
+    orderID string,
+    clOrdID string,
+    origClOrdID string,
+    ordStatus enum.OrdStatus,
+    responseTo enum.CxlRejResponseTo,
+    reason enum.CxlRejReason,
+    text string) *quickfix.Message {
+    msg := quickfix.NewMessage()
+    header := fix42.NewHeader(&msg.Header)
+    header.SetMsgType(enum.MsgType_ORDER_CANCEL_REJECT)
+
+    msg.Body.Set(field.NewOrderID(orderID))
+    msg.Body.Set(field.NewClOrdID(clOrdID))
+    msg.Body.Set(field.NewOrigClOrdID(origClOrdID))
+    msg.Body.Set(field.NewOrdStatus(ordStatus))
+    msg.Body.Set(field.NewCxlRejResponseTo(responseTo))
+    msg.Body.Set(field.NewCxlRejReason(reason))
+    msg.Body.Set(field.NewText(text))
+
+    return msg
+}
+
+//rejectCancel answers a cancel or cancel/replace request that cannot be applied to order, which may be nil if unknown
+func (e *executor) rejectCancel(order *Order, clOrdID string, origClOrdID string, responseTo enum.CxlRejResponseTo, sessionID quickfix.SessionID) {
+    orderID := "NONE"
+    ordStatus := enum.OrdStatus_REJECTED
+    reason := enum.CxlRejReason_UNKNOWN_ORDER
+    text := "Unknown order"
+
+    if order != nil {
+        orderID = order.OrderID
+        ordStatus = order.OrderStatus
+        reason = enum.CxlRejReason_TOO_LATE_TO_CANCEL
+        text = "Order is no longer working"
+    }
+
+    e.printf("[SERVER]: Rejecting cancel %v for %v: %v\n", clOrdID, origClOrdID, text)
+    e.send(newOrderCancelReject(orderID, clOrdID, origClOrdID, ordStatus, responseTo, reason, text), sessionID)
+}
+
+//rejectDuplicate answers a cancel or cancel/replace request reusing a ClOrdID the session already sent
+func (e *executor) rejectDuplicate(order *Order, clOrdID string, origClOrdID string, responseTo enum.CxlRejResponseTo, sessionID quickfix.SessionID) {
+    e.printf("[SERVER]: Rejecting cancel %v for %v: duplicate ClOrdID\n", clOrdID, origClOrdID)
+    e.send(newOrderCancelReject(order.OrderID, clOrdID, origClOrdID, order.OrderStatus, responseTo, enum.CxlRejReason_DUPLICATE_CLORDID, "Duplicate ClOrdID"), sessionID)
+}
+
+//isWorking reports whether order can still be cancelled or replaced
+func (o *Order) isWorking() bool {
+    switch o.OrderStatus {
+    case enum.OrdStatus_FILLED, enum.OrdStatus_CANCELED, enum.OrdStatus_REJECTED, enum.OrdStatus_EXPIRED:
+        return false
+    }
+    return true
+}
+
+func (e *executor) OnFIX42OrderCancelRequest(msg *quickfix.Message, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
+    origClOrdID, err := msg.Body.GetString(tag.OrigClOrdID)
+    if err != nil {
+        return
+    }
+
+    clOrdID, err := msg.Body.GetString(tag.ClOrdID)
+    if err != nil {
+        return
+    }
+
+    e.printf("[SERVER]: OrderCancelRequest %v for %v\n", clOrdID, origClOrdID)
+
+    order := e.findOrder(sessionID, origClOrdID)
+    if order == nil || !order.isWorking() {
+        e.rejectCancel(order, clOrdID, origClOrdID, enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, sessionID)
+        return
+    }
+
+    if e.findOrder(sessionID, clOrdID) != nil {
+        e.rejectDuplicate(order, clOrdID, origClOrdID, enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, sessionID)
+        return
+    }
+
+    if !e.removeStop(order) {
+        e.unbook(order)
+    }
+
+    order.OrigClOrdID = order.ClOrdID
+    order.ClOrdID = clOrdID
+    e.index(order)
+    e.cancel(order)
+
+    e.send(newExecutionReport(order), sessionID)
+
+    e.repeg(e.quotes[order.Symbol])
+
+    e.DumpOrders()
+    return
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+
+    fix42osr "github.com/quickfixgo/quickfix/fix42/orderstatusrequest"
+)
+
+//lookupOrder finds the order a status request of sessionID is about. OrderID, when given, is looked up
+//before ClOrdID and the order must also have the requested Symbol and Side, anything else is unknown.
+func (e *executor) lookupOrder(sessionID quickfix.SessionID, orderID string, clOrdID string, symbol string, side enum.Side) *Order {
+    var order *Order
+    if orderID != "" {
+        order = e.orderIDs[orderID]
+    } else {
+        order = e.findOrder(sessionID, clOrdID)
+    }
+
+    //orders of other sessions are as unknown as orders that never existed
+    if order == nil || order.SessionID != sessionID || order.Symbol != symbol || order.Side != side {
+        return nil
+    }
+    return order
+}
+
+func (e *executor) OnFIX42OrderStatusRequest(msg fix42osr.OrderStatusRequest, sessionID quickfix.SessionID) (err quickfix.MessageRejectError) {
+    clOrdID, err := msg.GetClOrdID()
+    if err != nil {
+        return
+    }
+
+    symbol, err := msg.GetSymbol()
+    if err != nil {
+        return
+    }
+
+    side, err := msg.GetSide()
+    if err != nil {
+        return
+    }
+
+    var orderID string
+    if msg.HasOrderID() {
+        if orderID, err = msg.GetOrderID(); err != nil {
+            return
+        }
+    }
+
+    e.printf("[SERVER]: OrderStatusRequest %v %v %v %v\n", clOrdID, orderID, symbol, side)
+
+    order := e.lookupOrder(sessionID, orderID, clOrdID, symbol, side)
+    if order == nil {
+        unknown := &Order{OrderID: orderID, ClOrdID: clOrdID, Symbol: symbol, Side: side}
+
+        e.reject(unknown, "Unknown order")
+        unknown.ExecTransType = enum.ExecTransType_STATUS
+        unknown.OrdRejReason = enum.OrdRejReason_UNKNOWN_ORDER
+        e.recordIDs()
+        e.send(newExecutionReport(unknown), sessionID)
+        return
+    }
+
+    //a status reply describes the order as it stands, it is not an execution of its own. FIX 4.2 gives
+    //ExecType the same values as OrdStatus.
+    status := *order
+    status.ExecTransType = enum.ExecTransType_STATUS
+    status.ExecType = enum.ExecType(order.OrderStatus)
+    status.ExecID = e.genExecID().Value()
+    status.LastPrice = decimal.Zero
+    status.LastShares = decimal.Zero
+
+    execReport := newExecutionReport(&status)
+    execReport.SetPrice(order.Price, 2)
+
+    if msg.HasAccount() {
+        acct, err := msg.GetAccount()
+        if err != nil {
+            return err
+        }
+        execReport.SetAccount(acct)
+    }
+
+    e.recordIDs()
+    e.send(execReport, sessionID)
+    return
+}
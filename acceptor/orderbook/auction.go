@@ -0,0 +1,122 @@
+package orderbook
+
+import (
+    "sort"
+
+    "github.com/shopspring/decimal"
+)
+
+//Call starts collecting orders for an auction, from now on orders rest without matching and the book may
+//cross until it is uncrossed
+func (b *Book) Call() {
+    b.call = true
+}
+
+//InCall reports whether the book is collecting orders for an auction
+func (b *Book) InCall() bool {
+    return b.call
+}
+
+//crossing returns the orders of side that trade at price in an auction, in priority order: market orders
+//first, then limit orders by price and arrival. All or none orders take no part in auctions, they wait for
+//continuous trading.
+func (b *Book) crossing(side Side, price decimal.Decimal) (entries []*entry) {
+    for el := b.markets[side].orders.Front(); el != nil; el = el.Next() {
+        if e := el.Value.(*entry); !e.AllOrNone {
+            entries = append(entries, e)
+        }
+    }
+
+    for lv := b.side(side).best(); lv != nil; lv = lv.next[0] {
+        if limit := (Order{Side: side, Price: lv.price}); !limit.crosses(price) {
+            break
+        }
+        for el := lv.orders.Front(); el != nil; el = el.Next() {
+            if e := el.Value.(*entry); !e.AllOrNone {
+                entries = append(entries, e)
+            }
+        }
+    }
+    return
+}
+
+func total(entries []*entry) decimal.Decimal {
+    sum := decimal.Zero
+    for _, e := range entries {
+        sum = sum.Add(e.Quantity)
+    }
+    return sum
+}
+
+//Indicative returns the price the book would uncross at now and the volume that would trade there, a zero
+//volume if it does not cross. The price is the one trading the most, then the one leaving the smallest
+//imbalance between buyers and sellers, then the one closest to reference, then the lowest. Every limit
+//price in the book is a candidate, and so is reference.
+func (b *Book) Indicative(reference decimal.Decimal) (price decimal.Decimal, volume decimal.Decimal) {
+    var candidates []decimal.Decimal
+    for _, side := range []Side{Buy, Sell} {
+        for lv := b.side(side).best(); lv != nil; lv = lv.next[0] {
+            candidates = append(candidates, lv.price)
+        }
+    }
+    if reference.Cmp(decimal.Zero) > 0 {
+        candidates = append(candidates, reference)
+    }
+    sort.Slice(candidates, func(i, j int) bool { return candidates[i].Cmp(candidates[j]) < 0 })
+
+    price, volume = decimal.Zero, decimal.Zero
+    imbalance := decimal.Zero
+    for _, candidate := range candidates {
+        buying, selling := total(b.crossing(Buy, candidate)), total(b.crossing(Sell, candidate))
+        traded := decimal.Min(buying, selling)
+        left := buying.Sub(selling).Abs()
+
+        var better bool
+        switch {
+        case traded.Cmp(decimal.Zero) <= 0:
+        case traded.Cmp(volume) != 0:
+            better = traded.Cmp(volume) > 0
+        case left.Cmp(imbalance) != 0:
+            better = left.Cmp(imbalance) < 0
+        default:
+            better = candidate.Sub(reference).Abs().Cmp(price.Sub(reference).Abs()) < 0
+        }
+
+        if better {
+            price, volume, imbalance = candidate, traded, left
+        }
+    }
+    return
+}
+
+//Uncross ends the call, every order crossing the price of Indicative trades there in priority order. The
+//book then matches again as orders come, market orders that did not trade are left for the caller to cancel.
+func (b *Book) Uncross(reference decimal.Decimal) (fills []Fill) {
+    price, volume := b.Indicative(reference)
+    b.call = false
+    if volume.Cmp(decimal.Zero) <= 0 {
+        return nil
+    }
+
+    buys, sells := b.crossing(Buy, price), b.crossing(Sell, price)
+    for volume.Cmp(decimal.Zero) > 0 {
+        buy, sell := buys[0], sells[0]
+        quantity := decimal.Min(volume, decimal.Min(buy.Quantity, sell.Quantity))
+
+        fill := Fill{Aggressor: buy.ID, Resting: sell.ID, Side: Buy, Price: price, Quantity: quantity, Auction: true}
+        b.record(fill)
+        fills = append(fills, fill)
+
+        b.take(buy, quantity)
+        b.take(sell, quantity)
+        volume = volume.Sub(quantity)
+
+        if buy.Quantity.Cmp(decimal.Zero) <= 0 {
+            buys = buys[1:]
+        }
+        if sell.Quantity.Cmp(decimal.Zero) <= 0 {
+            sells = sells[1:]
+        }
+    }
+    return
+}
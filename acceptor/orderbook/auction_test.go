@@ -0,0 +1,132 @@
+package orderbook
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestCall(t *testing.T) {
+    b := New()
+    b.Call()
+
+    b.Submit(limit("b1", Buy, "11", "10"))
+    if f, _ := b.Submit(limit("s1", Sell, "10", "10")); len(f) > 0 {
+        t.Errorf("fills %v during the call, expected the book to cross", fills(f))
+    }
+    b.Submit(market("b2", Buy, "5"))
+
+    immediate := limit("s2", Sell, "9", "5")
+    immediate.Immediate = true
+    b.Submit(immediate)
+    if _, ok := b.Get("s2"); ok {
+        t.Errorf("an immediate order rests during the call")
+    }
+    if _, ok := b.Get("b2"); !ok {
+        t.Errorf("a market order does not wait for the uncrossing")
+    }
+
+    if available := b.Available(limit("s3", Sell, "10", "10")); !available.Equals(d("0")) {
+        t.Errorf("%v available during the call, expected nothing", available)
+    }
+    if levels(b.Depth(Buy, 0))[0].price != "11" || levels(b.Depth(Sell, 0))[0].price != "10" {
+        t.Errorf("bids %v and asks %v, expected the crossed limit orders", levels(b.Depth(Buy, 0)), levels(b.Depth(Sell, 0)))
+    }
+
+    if err := b.Cancel("b2"); err != nil {
+        t.Errorf("cancelling a waiting market order: %v", err)
+    }
+
+    actual := b.Uncross(d("10.5"))
+    if expected := []fill{{"b1", "s1", "10.5", "10"}}; !reflect.DeepEqual(fills(actual), expected) {
+        t.Errorf("uncrossing fills %v, expected %v", fills(actual), expected)
+    }
+    if b.InCall() {
+        t.Errorf("the book is still in its call")
+    }
+
+    b.Submit(limit("s4", Sell, "10", "5"))
+    if f, _ := b.Submit(limit("b4", Buy, "10", "5")); len(f) != 1 {
+        t.Errorf("fills %v once uncrossed, expected the book to match again", fills(f))
+    }
+}
+
+func TestUncross(t *testing.T) {
+    aon := limit("s9", Sell, "9", "100")
+    aon.AllOrNone = true
+
+    tests := []struct {
+        name      string
+        orders    []Order
+        reference string
+        price     string
+        volume    string
+        fills     []fill
+    }{
+        {
+            name:      "a book that does not cross",
+            orders:    []Order{limit("b1", Buy, "9", "10"), limit("s1", Sell, "10", "10")},
+            reference: "9.5",
+            price:     "0",
+            volume:    "0",
+        },
+        {
+            name:      "the price trading the most",
+            orders:    []Order{limit("b1", Buy, "12", "10"), limit("b2", Buy, "11", "20"), limit("s1", Sell, "10", "15"), limit("s2", Sell, "11", "15")},
+            reference: "10",
+            price:     "11",
+            volume:    "30",
+            fills:     []fill{{"b1", "s1", "11", "10"}, {"b2", "s1", "11", "5"}, {"b2", "s2", "11", "15"}},
+        },
+        {
+            name:      "the smallest imbalance among the prices trading the most",
+            orders:    []Order{limit("b1", Buy, "12", "20"), limit("s1", Sell, "10", "10"), limit("s2", Sell, "11", "10"), limit("s3", Sell, "12", "5")},
+            reference: "12",
+            price:     "11",
+            volume:    "20",
+            fills:     []fill{{"b1", "s1", "11", "10"}, {"b1", "s2", "11", "10"}},
+        },
+        {
+            name:      "the price closest to the reference when volume and imbalance are even",
+            orders:    []Order{limit("b1", Buy, "12", "10"), limit("s1", Sell, "10", "10")},
+            reference: "11",
+            price:     "11",
+            volume:    "10",
+            fills:     []fill{{"b1", "s1", "11", "10"}},
+        },
+        {
+            name:      "market orders trade first",
+            orders:    []Order{limit("b1", Buy, "10", "10"), market("b2", Buy, "10"), limit("s1", Sell, "10", "15")},
+            reference: "10",
+            price:     "10",
+            volume:    "15",
+            fills:     []fill{{"b2", "s1", "10", "10"}, {"b1", "s1", "10", "5"}},
+        },
+        {
+            name:      "all or none orders wait for continuous trading",
+            orders:    []Order{aon, limit("b1", Buy, "10", "10"), limit("s1", Sell, "10", "5")},
+            reference: "10",
+            price:     "10",
+            volume:    "5",
+            fills:     []fill{{"b1", "s1", "10", "5"}},
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        b.Call()
+        for _, o := range test.orders {
+            if _, err := b.Submit(o); err != nil {
+                t.Fatalf("%v: %v", test.name, err)
+            }
+        }
+
+        price, volume := b.Indicative(d(test.reference))
+        if price.String() != test.price || volume.String() != test.volume {
+            t.Errorf("%v: indicative %v@%v, expected %v@%v", test.name, volume, price, test.volume, test.price)
+        }
+
+        if actual := b.Uncross(d(test.reference)); !reflect.DeepEqual(fills(actual), test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, fills(actual), test.fills)
+        }
+    }
+}
@@ -0,0 +1,104 @@
+package orderbook
+
+import (
+    "container/list"
+    "math/rand"
+
+    "github.com/shopspring/decimal"
+)
+
+//maxHeight bounds the skip list, enough for millions of price levels
+const maxHeight = 20
+
+//priceLevel is the FIFO queue of orders resting at one price, quantity is what they show and reserve what
+//icebergs hide. allOrNone counts the all or none orders in the queue.
+type priceLevel struct {
+    price     decimal.Decimal
+    quantity  decimal.Decimal
+    reserve   decimal.Decimal
+    orders    *list.List
+    allOrNone int
+
+    next []*priceLevel
+}
+
+//ladder is one side of the book, its price levels are kept best first in a skip list so that a level is
+//found or inserted in O(log n)
+type ladder struct {
+    head   priceLevel
+    height int
+    better func(a, b decimal.Decimal) bool
+    random *rand.Rand
+}
+
+func newLadder(better func(a, b decimal.Decimal) bool) *ladder {
+    return &ladder{
+        head:   priceLevel{next: make([]*priceLevel, maxHeight)},
+        height: 1,
+        better: better,
+        //a fixed seed keeps the shape of the list, and so every run of the book, deterministic
+        random: rand.New(rand.NewSource(1)),
+    }
+}
+
+//best returns the level with the best price, nil if the side is empty
+func (l *ladder) best() *priceLevel {
+    return l.head.next[0]
+}
+
+//search returns, for every height, the last level priced better than price
+func (l *ladder) search(price decimal.Decimal) (update [maxHeight]*priceLevel) {
+    x := &l.head
+    for i := l.height - 1; i >= 0; i-- {
+        for x.next[i] != nil && l.better(x.next[i].price, price) {
+            x = x.next[i]
+        }
+        update[i] = x
+    }
+    return
+}
+
+func (l *ladder) randomHeight() int {
+    height := 1
+    for height < maxHeight && l.random.Intn(4) == 0 {
+        height++
+    }
+    return height
+}
+
+//insert returns the level at price, creating it if needed
+func (l *ladder) insert(price decimal.Decimal) *priceLevel {
+    update := l.search(price)
+    if candidate := update[0].next[0]; candidate != nil && candidate.price.Equals(price) {
+        return candidate
+    }
+
+    height := l.randomHeight()
+    for i := l.height; i < height; i++ {
+        update[i] = &l.head
+    }
+    if height > l.height {
+        l.height = height
+    }
+
+    lv := &priceLevel{price: price, quantity: decimal.Zero, reserve: decimal.Zero, orders: list.New(), next: make([]*priceLevel, height)}
+    for i := 0; i < height; i++ {
+        lv.next[i] = update[i].next[i]
+        update[i].next[i] = lv
+    }
+    return lv
+}
+
+//remove unlinks lv from the ladder
+func (l *ladder) remove(lv *priceLevel) {
+    update := l.search(lv.price)
+    for i := 0; i < len(lv.next); i++ {
+        if update[i].next[i] == lv {
+            update[i].next[i] = lv.next[i]
+        }
+    }
+
+    for l.height > 1 && l.head.next[l.height-1] == nil {
+        l.height--
+    }
+}
@@ -0,0 +1,591 @@
+//Package orderbook is a limit order book matching incoming orders against resting ones by price-time priority.
+//It knows nothing about FIX, orders are identified by an ID chosen by the caller.
+package orderbook
+
+import (
+    "container/list"
+    "errors"
+
+    "github.com/shopspring/decimal"
+)
+
+//Side is the side of the book an order is on
+type Side int
+
+const (
+    Buy Side = iota
+    Sell
+)
+
+//Prevention is what the book does when an order would trade with a resting order of the same Owner
+type Prevention int
+
+const (
+    //AllowSelfTrade lets orders of the same owner trade with each other
+    AllowSelfTrade Prevention = iota
+    //CancelResting cancels the resting order and goes on matching the incoming one
+    CancelResting
+    //CancelAggressor cancels whatever is left of the incoming order
+    CancelAggressor
+    //CancelBoth cancels the resting order and whatever is left of the incoming one
+    CancelBoth
+    //DecrementAndCancel takes the smaller quantity of the two off both orders, cancelling the smaller one
+    DecrementAndCancel
+)
+
+var (
+    //ErrDuplicateID is returned when an order is submitted with the ID of an order already resting
+    ErrDuplicateID = errors.New("orderbook: duplicate order id")
+    //ErrUnknownOrder is returned when the order to cancel or amend is not resting in the book
+    ErrUnknownOrder = errors.New("orderbook: unknown order")
+    //ErrInvalidQuantity is returned for orders of a zero or negative quantity
+    ErrInvalidQuantity = errors.New("orderbook: quantity must be positive")
+    //ErrMinQuantity is returned, and the order dropped, when an order cannot fill the quantity it requires on arrival
+    ErrMinQuantity = errors.New("orderbook: minimum quantity not available")
+)
+
+//Order is a request to trade Quantity at Price or better, a Market order trades at any price
+type Order struct {
+    ID       string
+    Side     Side
+    Market   bool
+    Price    decimal.Decimal
+    Quantity decimal.Decimal
+
+    //Immediate orders never rest, whatever does not fill on arrival is dropped
+    Immediate bool
+
+    //MinQuantity is the least an order must fill on arrival for it to trade at all, it does not apply once
+    //the order rests
+    MinQuantity decimal.Decimal
+
+    //AllOrNone orders only ever trade their whole Quantity at once. They rest until an order large enough
+    //comes, even if smaller orders cross them meanwhile. Depth and Best leave them out.
+    AllOrNone bool
+
+    //Display is the largest quantity a resting order shows, an iceberg holds the rest of it in reserve and
+    //shows it slice by slice. A Display of 0 shows the whole order.
+    Display decimal.Decimal
+
+    //Owner groups the orders Prevention applies to, orders without an Owner never self-trade. The Prevention
+    //of the incoming order decides.
+    Owner      string
+    Prevention Prevention
+
+    //Discretion is how far past Price an order is willing to trade. It shows and queues at Price, but trades
+    //with any order within Discretion of it.
+    Discretion decimal.Decimal
+
+    //Pegged orders follow the prices of other orders, they are left out of Best
+    Pegged bool
+
+    //Sequence is the arrival time of a resting order, it is set by the book
+    Sequence uint64
+}
+
+//Fill is one execution between an incoming order and an order resting in the book. A SelfTrade fill is a
+//trade the book prevented, nothing traded: Quantity was cancelled from the resting order and
+//AggressorQuantity from the incoming one.
+type Fill struct {
+    Aggressor string
+    Resting   string
+    Side      Side
+    Price     decimal.Decimal
+    Quantity  decimal.Decimal
+
+    SelfTrade         bool            `json:",omitempty"`
+    AggressorQuantity decimal.Decimal
+
+    //Auction fills are trades of an uncrossing, both orders were resting and Aggressor is the buyer
+    Auction bool `json:",omitempty"`
+}
+
+//Level is the quantity shown at one price, the reserve of icebergs is not part of it
+type Level struct {
+    Price    decimal.Decimal
+    Quantity decimal.Decimal
+    Orders   int
+}
+
+//entry is an order resting in the book with its place in the queue of its price level. visible is the
+//part of Quantity shown, all of it unless the order is an iceberg.
+type entry struct {
+    Order
+    visible decimal.Decimal
+    level   *priceLevel
+    element *list.Element
+}
+
+//slice is the quantity e shows next
+func (e *entry) slice() decimal.Decimal {
+    if e.Display.Cmp(decimal.Zero) > 0 {
+        return decimal.Min(e.Display, e.Quantity)
+    }
+    return e.Quantity
+}
+
+//Book is the order book of one instrument. Orders rest in FIFO queues per price level, filled strictly by
+//price then arrival. It is not safe for concurrent use.
+type Book struct {
+    bids     *ladder
+    asks     *ladder
+    orders   map[string]*entry
+    sequence uint64
+
+    //trades holds the last fills of the book, traded counts every fill it ever made
+    trades []Fill
+    traded uint64
+
+    //discretion is the number of resting orders with a Discretion
+    discretion int
+
+    //call is set while orders are collected for an auction, markets queue the market orders waiting for it
+    call    bool
+    markets [2]*priceLevel
+}
+
+//New returns an empty book
+func New() *Book {
+    return &Book{
+        bids:   newLadder(func(a, b decimal.Decimal) bool { return a.Cmp(b) > 0 }),
+        asks:   newLadder(func(a, b decimal.Decimal) bool { return a.Cmp(b) < 0 }),
+        orders: make(map[string]*entry),
+        markets: [2]*priceLevel{
+            {quantity: decimal.Zero, reserve: decimal.Zero, orders: list.New()},
+            {quantity: decimal.Zero, reserve: decimal.Zero, orders: list.New()},
+        },
+    }
+}
+
+//crosses reports whether o is willing to trade at price
+func (o *Order) crosses(price decimal.Decimal) bool {
+    switch {
+    case o.Market:
+        return true
+    case o.Side == Buy:
+        return o.Price.Add(o.Discretion).Cmp(price) >= 0
+    default:
+        return o.Price.Sub(o.Discretion).Cmp(price) <= 0
+    }
+}
+
+//side returns the levels of side
+func (b *Book) side(side Side) *ladder {
+    if side == Buy {
+        return b.bids
+    }
+    return b.asks
+}
+
+//opposite returns the levels an order on side trades against
+func (b *Book) opposite(side Side) *ladder {
+    if side == Buy {
+        return b.asks
+    }
+    return b.bids
+}
+
+//rest queues o behind every order at the same or a better price
+func (b *Book) rest(o Order) {
+    b.sequence++
+    o.Sequence = b.sequence
+    o.MinQuantity = decimal.Zero
+
+    lv := b.markets[o.Side]
+    if !o.Market {
+        lv = b.side(o.Side).insert(o.Price)
+    }
+    e := &entry{Order: o, level: lv}
+    e.visible = e.slice()
+    e.element = lv.orders.PushBack(e)
+    lv.quantity = lv.quantity.Add(e.visible)
+    lv.reserve = lv.reserve.Add(e.Quantity.Sub(e.visible))
+    b.orders[o.ID] = e
+    if e.Discretion.Cmp(decimal.Zero) > 0 {
+        b.discretion++
+    }
+    if e.AllOrNone {
+        lv.allOrNone++
+    }
+}
+
+//replenish shows the next slice of the reserve of an iceberg whose visible quantity is used up. The new
+//slice goes behind every order already at its price.
+func (b *Book) replenish(e *entry) {
+    lv := e.level
+    e.visible = e.slice()
+    lv.quantity = lv.quantity.Add(e.visible)
+    lv.reserve = lv.reserve.Sub(e.visible)
+
+    b.sequence++
+    e.Sequence = b.sequence
+    lv.orders.Remove(e.element)
+    e.element = lv.orders.PushBack(e)
+}
+
+//take removes quantity from e, its visible part first, unlinking e once nothing is left and replenishing
+//it once nothing is visible
+func (b *Book) take(e *entry, quantity decimal.Decimal) {
+    lv := e.level
+    visible := decimal.Min(quantity, e.visible)
+    e.visible = e.visible.Sub(visible)
+    lv.quantity = lv.quantity.Sub(visible)
+    lv.reserve = lv.reserve.Sub(quantity.Sub(visible))
+    e.Quantity = e.Quantity.Sub(quantity)
+
+    switch {
+    case e.Quantity.Cmp(decimal.Zero) <= 0:
+        b.unlink(e)
+    case e.visible.Cmp(decimal.Zero) <= 0:
+        b.replenish(e)
+    }
+}
+
+//unlink takes e out of the queue of its level, dropping the level once it is empty
+func (b *Book) unlink(e *entry) {
+    lv := e.level
+    lv.orders.Remove(e.element)
+    lv.quantity = lv.quantity.Sub(e.visible)
+    lv.reserve = lv.reserve.Sub(e.Quantity.Sub(e.visible))
+    if lv.orders.Len() == 0 && !e.Market {
+        b.side(e.Side).remove(lv)
+    }
+    delete(b.orders, e.ID)
+    if e.Discretion.Cmp(decimal.Zero) > 0 {
+        b.discretion--
+    }
+    if e.AllOrNone {
+        lv.allOrNone--
+    }
+}
+
+//first returns the first order of lv that o can trade with, all or none orders larger than o are passed over
+func (lv *priceLevel) first(o *Order) *entry {
+    for el := lv.orders.Front(); el != nil; el = el.Next() {
+        if e := el.Value.(*entry); !e.AllOrNone || o.Quantity.Cmp(e.Quantity) >= 0 {
+            return e
+        }
+    }
+    return nil
+}
+
+//discretionary returns the first order of the opposite side of o whose discretion reaches the price of o
+func (b *Book) discretionary(o *Order) *entry {
+    if b.discretion == 0 || o.Market {
+        return nil
+    }
+
+    for lv := b.opposite(o.Side).best(); lv != nil; lv = lv.next[0] {
+        for el := lv.orders.Front(); el != nil; el = el.Next() {
+            e := el.Value.(*entry)
+            if e.Discretion.Cmp(decimal.Zero) > 0 && e.crosses(o.Price) && (!e.AllOrNone || o.Quantity.Cmp(e.Quantity) >= 0) {
+                return e
+            }
+        }
+    }
+    return nil
+}
+
+//trade executes o with resting at price, or prevents it if they have the same owner
+func (b *Book) trade(o *Order, resting *entry, price decimal.Decimal) Fill {
+    if o.Prevention != AllowSelfTrade && o.Owner != "" && o.Owner == resting.Owner {
+        return b.prevent(o, resting)
+    }
+    return b.execute(o, resting, price)
+}
+
+//match executes o against the opposite side for as long as it crosses, then against the resting orders
+//whose discretion reaches its price
+func (b *Book) match(o *Order) (fills []Fill) {
+    lv := b.opposite(o.Side).best()
+
+    for o.Quantity.Cmp(decimal.Zero) > 0 && lv != nil && o.crosses(lv.price) {
+        resting := lv.first(o)
+        if resting == nil {
+            lv = lv.next[0]
+            continue
+        }
+
+        fills = append(fills, b.trade(o, resting, resting.Price))
+
+        //a level that has been emptied is out of the ladder, but still links to the level after it
+        if lv.orders.Len() == 0 {
+            lv = lv.next[0]
+        }
+    }
+
+    //a resting order trading on its discretion does so at the price of o
+    for o.Quantity.Cmp(decimal.Zero) > 0 {
+        resting := b.discretionary(o)
+        if resting == nil {
+            break
+        }
+        fills = append(fills, b.trade(o, resting, o.Price))
+    }
+    return
+}
+
+//execute trades o with resting at price
+func (b *Book) execute(o *Order, resting *entry, price decimal.Decimal) Fill {
+    //only the visible slice of an iceberg trades before it goes back in the queue
+    quantity := decimal.Min(o.Quantity, resting.visible)
+    if resting.AllOrNone {
+        quantity = resting.Quantity
+    }
+
+    fill := Fill{Aggressor: o.ID, Resting: resting.ID, Side: o.Side, Price: price, Quantity: quantity}
+    b.record(fill)
+
+    o.Quantity = o.Quantity.Sub(quantity)
+    b.take(resting, quantity)
+    return fill
+}
+
+//prevent keeps o from trading with resting, an order of the same owner, as the Prevention of o asks
+func (b *Book) prevent(o *Order, resting *entry) Fill {
+    fill := Fill{Aggressor: o.ID, Resting: resting.ID, Side: o.Side, Price: resting.Price, Quantity: decimal.Zero, AggressorQuantity: decimal.Zero, SelfTrade: true}
+
+    switch o.Prevention {
+    case CancelResting:
+        fill.Quantity = resting.Quantity
+    case CancelBoth:
+        fill.Quantity = resting.Quantity
+        fill.AggressorQuantity = o.Quantity
+    case DecrementAndCancel:
+        fill.Quantity = decimal.Min(o.Quantity, resting.Quantity)
+        fill.AggressorQuantity = fill.Quantity
+    default:
+        fill.AggressorQuantity = o.Quantity
+    }
+
+    o.Quantity = o.Quantity.Sub(fill.AggressorQuantity)
+    if fill.Quantity.Cmp(decimal.Zero) > 0 {
+        b.take(resting, fill.Quantity)
+    }
+    return fill
+}
+
+//Submit matches o against the book and rests whatever is left of it unless it is a market or immediate order.
+//The fills are returned in the order they happened. During a call nothing matches, orders rest until the
+//book is uncrossed and market orders wait for it too, only immediate orders are dropped.
+func (b *Book) Submit(o Order) ([]Fill, error) {
+    if o.Quantity.Cmp(decimal.Zero) <= 0 {
+        return nil, ErrInvalidQuantity
+    }
+    if _, ok := b.orders[o.ID]; ok {
+        return nil, ErrDuplicateID
+    }
+
+    if b.call {
+        if o.MinQuantity.Cmp(decimal.Zero) > 0 {
+            return nil, ErrMinQuantity
+        }
+        if !o.Immediate {
+            b.rest(o)
+        }
+        return nil, nil
+    }
+
+    if o.MinQuantity.Cmp(decimal.Zero) > 0 || o.AllOrNone {
+        available := b.Available(o)
+        if available.Cmp(o.MinQuantity) < 0 {
+            return nil, ErrMinQuantity
+        }
+
+        //an all or none order that cannot fill entirely does not trade, it rests unless it is immediate
+        if o.AllOrNone && available.Cmp(o.Quantity) < 0 {
+            if o.Market || o.Immediate {
+                return nil, ErrMinQuantity
+            }
+            b.rest(o)
+            return nil, nil
+        }
+    }
+
+    fills := b.match(&o)
+
+    if o.Quantity.Cmp(decimal.Zero) > 0 && !o.Market && !o.Immediate {
+        b.rest(o)
+    }
+    return fills, nil
+}
+
+//Cancel takes the order with id out of the book
+func (b *Book) Cancel(id string) error {
+    e, ok := b.orders[id]
+    if !ok {
+        return ErrUnknownOrder
+    }
+
+    b.unlink(e)
+    return nil
+}
+
+//Amend changes the price and remaining quantity of a resting order. The order keeps its time priority only
+//when its quantity is reduced at an unchanged price, otherwise it is matched again as if it had just arrived.
+func (b *Book) Amend(id string, price decimal.Decimal, quantity decimal.Decimal) ([]Fill, error) {
+    e, ok := b.orders[id]
+    if !ok {
+        return nil, ErrUnknownOrder
+    }
+    if quantity.Cmp(decimal.Zero) <= 0 {
+        return nil, ErrInvalidQuantity
+    }
+
+    if price.Equals(e.Price) && quantity.Cmp(e.Quantity) <= 0 {
+        //an iceberg gives up its reserve before its visible slice
+        visible := decimal.Min(e.visible, quantity)
+        e.level.quantity = e.level.quantity.Sub(e.visible).Add(visible)
+        e.level.reserve = e.level.reserve.Sub(e.Quantity.Sub(e.visible)).Add(quantity.Sub(visible))
+        e.visible = visible
+        e.Quantity = quantity
+        return nil, nil
+    }
+
+    b.unlink(e)
+
+    amended := e.Order
+    amended.Price = price
+    amended.Quantity = quantity
+    return b.Submit(amended)
+}
+
+//Reduce takes quantity off the order resting with id as a fill would, its visible slice first
+func (b *Book) Reduce(id string, quantity decimal.Decimal) error {
+    e, ok := b.orders[id]
+    if !ok {
+        return ErrUnknownOrder
+    }
+    if quantity.Cmp(decimal.Zero) <= 0 {
+        return ErrInvalidQuantity
+    }
+
+    b.take(e, decimal.Min(quantity, e.Quantity))
+    return nil
+}
+
+//Get returns the order resting with id
+func (b *Book) Get(id string) (Order, bool) {
+    e, ok := b.orders[id]
+    if !ok {
+        return Order{}, false
+    }
+    return e.Order, true
+}
+
+//shown returns what lv shows of the orders in it that are not all or none
+func (lv *priceLevel) shown() Level {
+    level := Level{Price: lv.price, Quantity: lv.quantity, Orders: lv.orders.Len()}
+    if lv.allOrNone == 0 {
+        return level
+    }
+
+    for el := lv.orders.Front(); el != nil; el = el.Next() {
+        if e := el.Value.(*entry); e.AllOrNone {
+            level.Quantity = level.Quantity.Sub(e.visible)
+            level.Orders--
+        }
+    }
+    return level
+}
+
+//Depth returns up to levels price levels of side, best first, with the quantity at each price aggregated.
+//A levels of 0 returns the whole side. All or none orders are left out, they cannot trade with every order
+//that crosses them, so a level holding nothing else is not shown.
+func (b *Book) Depth(side Side, levels int) []Level {
+    var depth []Level
+    for lv := b.side(side).best(); lv != nil; lv = lv.next[0] {
+        if levels > 0 && len(depth) == levels {
+            break
+        }
+        if level := lv.shown(); level.Orders > 0 {
+            depth = append(depth, level)
+        }
+    }
+    return depth
+}
+
+//Best returns the best price of side among the orders that are neither pegged nor all or none, false if
+//there is none
+func (b *Book) Best(side Side) (decimal.Decimal, bool) {
+    for lv := b.side(side).best(); lv != nil; lv = lv.next[0] {
+        for el := lv.orders.Front(); el != nil; el = el.Next() {
+            if e := el.Value.(*entry); !e.Pegged && !e.AllOrNone {
+                return lv.price, true
+            }
+        }
+    }
+    return decimal.Zero, false
+}
+
+//Orders returns the orders resting on side in priority order
+func (b *Book) Orders(side Side) []Order {
+    var orders []Order
+    for lv := b.side(side).best(); lv != nil; lv = lv.next[0] {
+        for el := lv.orders.Front(); el != nil; el = el.Next() {
+            orders = append(orders, el.Value.(*entry).Order)
+        }
+    }
+    return orders
+}
+
+//Available returns how much of o could execute against the book right now, the reserve of icebergs included.
+//Resting orders of the owner of o count as its Prevention would have them trade: not at all, and matching
+//stops at the first of them if it cancels o. The discretion of resting orders is not counted, and nothing is
+//available during a call.
+func (b *Book) Available(o Order) decimal.Decimal {
+    total := decimal.Zero
+    if b.call {
+        return total
+    }
+    for lv := b.opposite(o.Side).best(); lv != nil && o.crosses(lv.price) && total.Cmp(o.Quantity) < 0; lv = lv.next[0] {
+        for el := lv.orders.Front(); el != nil && total.Cmp(o.Quantity) < 0; el = el.Next() {
+            e := el.Value.(*entry)
+            remaining := o.Quantity.Sub(total)
+            if e.AllOrNone && remaining.Cmp(e.Quantity) < 0 {
+                continue
+            }
+
+            if o.Prevention != AllowSelfTrade && o.Owner != "" && o.Owner == e.Owner {
+                switch o.Prevention {
+                case CancelResting:
+                case DecrementAndCancel:
+                    o.Quantity = o.Quantity.Sub(decimal.Min(remaining, e.Quantity))
+                default:
+                    return total
+                }
+                continue
+            }
+            total = total.Add(decimal.Min(remaining, e.Quantity))
+        }
+    }
+    return total
+}
+
+//keptTrades is the least number of fills the book remembers, older ones are forgotten
+const keptTrades = 1024
+
+//record adds fill to the trades of the book. The oldest fills are dropped once twice keptTrades are held, so
+//the trades never grow without bound.
+func (b *Book) record(fill Fill) {
+    b.trades = append(b.trades, fill)
+    b.traded++
+
+    if len(b.trades) >= 2*keptTrades {
+        b.trades = append([]Fill(nil), b.trades[len(b.trades)-keptTrades:]...)
+    }
+}
+
+//TradesSince returns the fills the book made after its first n, oldest first, and how many it made so far to
+//ask for the next ones with. Only the last keptTrades fills are sure to be remembered. Prevented self-trades
+//are not trades.
+func (b *Book) TradesSince(n uint64) ([]Fill, uint64) {
+    first := b.traded - uint64(len(b.trades))
+    if n < first {
+        n = first
+    }
+    if n >= b.traded {
+        return nil, b.traded
+    }
+    return b.trades[n-first:], b.traded
+}
@@ -0,0 +1,948 @@
+package orderbook
+
+import (
+    "math/rand"
+    "reflect"
+    "sort"
+    "strconv"
+    "testing"
+
+    "github.com/shopspring/decimal"
+)
+
+func d(s string) decimal.Decimal {
+    v, err := decimal.NewFromString(s)
+    if err != nil {
+        panic(err)
+    }
+    return v
+}
+
+func limit(id string, side Side, price string, quantity string) Order {
+    return Order{ID: id, Side: side, Price: d(price), Quantity: d(quantity)}
+}
+
+func market(id string, side Side, quantity string) Order {
+    return Order{ID: id, Side: side, Market: true, Quantity: d(quantity)}
+}
+
+//fill is a Fill written as strings so that tables stay readable
+type fill struct {
+    aggressor, resting string
+    price, quantity    string
+}
+
+//level is a Level written as strings
+type level struct {
+    price, quantity string
+    orders          int
+}
+
+func fills(actual []Fill) []fill {
+    var f []fill
+    for _, a := range actual {
+        f = append(f, fill{a.Aggressor, a.Resting, a.Price.String(), a.Quantity.String()})
+    }
+    return f
+}
+
+func levels(actual []Level) []level {
+    var l []level
+    for _, a := range actual {
+        l = append(l, level{a.Price.String(), a.Quantity.String(), a.Orders})
+    }
+    return l
+}
+
+func TestSubmit(t *testing.T) {
+    tests := []struct {
+        name  string
+        book  []Order
+        order Order
+        fills []fill
+        bids  []level
+        asks  []level
+    }{
+        {
+            name:  "limit order rests in an empty book",
+            order: limit("b1", Buy, "10", "100"),
+            bids:  []level{{"10", "100", 1}},
+        },
+        {
+            name:  "market order on an empty book is dropped",
+            order: market("b1", Buy, "100"),
+        },
+        {
+            name:  "immediate order on an empty book is dropped",
+            order: Order{ID: "b1", Side: Buy, Price: d("10"), Quantity: d("100"), Immediate: true},
+        },
+        {
+            name:  "orders that do not cross both rest",
+            book:  []Order{limit("s1", Sell, "11", "100")},
+            order: limit("b1", Buy, "10", "100"),
+            bids:  []level{{"10", "100", 1}},
+            asks:  []level{{"11", "100", 1}},
+        },
+        {
+            name:  "crossing order fills at the resting price",
+            book:  []Order{limit("s1", Sell, "10", "100")},
+            order: limit("b1", Buy, "11", "100"),
+            fills: []fill{{"b1", "s1", "10", "100"}},
+        },
+        {
+            name:  "aggressor partially filled rests the remainder",
+            book:  []Order{limit("s1", Sell, "10", "40")},
+            order: limit("b1", Buy, "10", "100"),
+            fills: []fill{{"b1", "s1", "10", "40"}},
+            bids:  []level{{"10", "60", 1}},
+        },
+        {
+            name:  "resting order partially filled keeps the remainder",
+            book:  []Order{limit("b1", Buy, "10", "100")},
+            order: limit("s1", Sell, "9", "30"),
+            fills: []fill{{"s1", "b1", "10", "30"}},
+            bids:  []level{{"10", "70", 1}},
+        },
+        {
+            name:  "market order sweeps several levels",
+            book:  []Order{limit("s1", Sell, "10", "50"), limit("s2", Sell, "11", "50"), limit("s3", Sell, "12", "50")},
+            order: market("b1", Buy, "120"),
+            fills: []fill{{"b1", "s1", "10", "50"}, {"b1", "s2", "11", "50"}, {"b1", "s3", "12", "20"}},
+            asks:  []level{{"12", "30", 1}},
+        },
+        {
+            name:  "market order larger than the book drops the remainder",
+            book:  []Order{limit("b1", Buy, "10", "50")},
+            order: market("s1", Sell, "80"),
+            fills: []fill{{"s1", "b1", "10", "50"}},
+        },
+        {
+            name:  "limit order stops at its limit price",
+            book:  []Order{limit("s1", Sell, "10", "50"), limit("s2", Sell, "11", "50")},
+            order: limit("b1", Buy, "10", "80"),
+            fills: []fill{{"b1", "s1", "10", "50"}},
+            bids:  []level{{"10", "30", 1}},
+            asks:  []level{{"11", "50", 1}},
+        },
+        {
+            name:  "better price trades first",
+            book:  []Order{limit("b1", Buy, "9", "50"), limit("b2", Buy, "10", "50")},
+            order: limit("s1", Sell, "9", "60"),
+            fills: []fill{{"s1", "b2", "10", "50"}, {"s1", "b1", "9", "10"}},
+            bids:  []level{{"9", "40", 1}},
+        },
+        {
+            name:  "earlier order at the same price trades first",
+            book:  []Order{limit("s1", Sell, "10", "50"), limit("s2", Sell, "10", "50"), limit("s3", Sell, "10", "50")},
+            order: limit("b1", Buy, "10", "70"),
+            fills: []fill{{"b1", "s1", "10", "50"}, {"b1", "s2", "10", "20"}},
+            asks:  []level{{"10", "80", 2}},
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        for _, o := range test.book {
+            if _, err := b.Submit(o); err != nil {
+                t.Fatalf("%v: seeding %v: %v", test.name, o.ID, err)
+            }
+        }
+
+        actual, err := b.Submit(test.order)
+        if err != nil {
+            t.Errorf("%v: unexpected error %v", test.name, err)
+            continue
+        }
+
+        if f := fills(actual); !reflect.DeepEqual(f, test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, f, test.fills)
+        }
+        if l := levels(b.Depth(Buy, 0)); !reflect.DeepEqual(l, test.bids) {
+            t.Errorf("%v: bids %v, expected %v", test.name, l, test.bids)
+        }
+        if l := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(l, test.asks) {
+            t.Errorf("%v: asks %v, expected %v", test.name, l, test.asks)
+        }
+        if trades, _ := b.TradesSince(0); !reflect.DeepEqual(fills(trades), test.fills) {
+            t.Errorf("%v: trades %v, expected %v", test.name, fills(trades), test.fills)
+        }
+    }
+}
+
+func TestTradesSince(t *testing.T) {
+    b := New()
+    trade := func(i int) {
+        b.Submit(limit("s"+strconv.Itoa(i), Sell, "10", "1"))
+        b.Submit(limit("b"+strconv.Itoa(i), Buy, "10", "1"))
+    }
+
+    trade(0)
+    trade(1)
+    trades, n := b.TradesSince(0)
+    if n != 2 || !reflect.DeepEqual(fills(trades), []fill{{"b0", "s0", "10", "1"}, {"b1", "s1", "10", "1"}}) {
+        t.Fatalf("trades %v up to %v", fills(trades), n)
+    }
+
+    trade(2)
+    if trades, n = b.TradesSince(n); n != 3 || !reflect.DeepEqual(fills(trades), []fill{{"b2", "s2", "10", "1"}}) {
+        t.Fatalf("trades %v up to %v, expected only b2 up to 3", fills(trades), n)
+    }
+    if trades, n = b.TradesSince(n); n != 3 || len(trades) != 0 {
+        t.Fatalf("trades %v up to %v, expected none", fills(trades), n)
+    }
+
+    //old trades are forgotten, the count goes on
+    for i := 3; i < 3*keptTrades; i++ {
+        trade(i)
+    }
+    trades, n = b.TradesSince(0)
+    if n != 3*keptTrades || len(trades) < keptTrades || len(trades) >= 2*keptTrades {
+        t.Fatalf("%v trades kept up to %v", len(trades), n)
+    }
+    if last := trades[len(trades)-1]; last.Aggressor != "b"+strconv.Itoa(3*keptTrades-1) {
+        t.Errorf("last trade %+v", last)
+    }
+    if trades, _ = b.TradesSince(n - 1); len(trades) != 1 {
+        t.Errorf("%v trades after %v, expected 1", len(trades), n-1)
+    }
+}
+
+func TestSubmitErrors(t *testing.T) {
+    tests := []struct {
+        name  string
+        book  []Order
+        order Order
+        err   error
+    }{
+        {"zero quantity", nil, limit("b1", Buy, "10", "0"), ErrInvalidQuantity},
+        {"negative quantity", nil, limit("b1", Buy, "10", "-5"), ErrInvalidQuantity},
+        {"duplicate id", []Order{limit("b1", Buy, "10", "10")}, limit("b1", Buy, "9", "10"), ErrDuplicateID},
+    }
+
+    for _, test := range tests {
+        b := New()
+        for _, o := range test.book {
+            b.Submit(o)
+        }
+
+        if _, err := b.Submit(test.order); err != test.err {
+            t.Errorf("%v: error %v, expected %v", test.name, err, test.err)
+        }
+    }
+}
+
+func TestCancel(t *testing.T) {
+    b := New()
+    b.Submit(limit("b1", Buy, "10", "10"))
+    b.Submit(limit("b2", Buy, "10", "20"))
+
+    if err := b.Cancel("b1"); err != nil {
+        t.Fatalf("cancel b1: %v", err)
+    }
+    if err := b.Cancel("b1"); err != ErrUnknownOrder {
+        t.Errorf("second cancel of b1: error %v, expected %v", err, ErrUnknownOrder)
+    }
+    if err := b.Cancel("missing"); err != ErrUnknownOrder {
+        t.Errorf("cancel of an unknown order: error %v, expected %v", err, ErrUnknownOrder)
+    }
+
+    expected := []level{{"10", "20", 1}}
+    if l := levels(b.Depth(Buy, 0)); !reflect.DeepEqual(l, expected) {
+        t.Errorf("bids %v, expected %v", l, expected)
+    }
+}
+
+func TestAmend(t *testing.T) {
+    tests := []struct {
+        name     string
+        price    string
+        quantity string
+        fills    []fill
+        asks     []level
+        first    string
+    }{
+        {
+            name:     "reducing the quantity keeps priority",
+            price:    "10",
+            quantity: "5",
+            asks:     []level{{"10", "15", 2}},
+            first:    "s1",
+        },
+        {
+            name:     "increasing the quantity loses priority",
+            price:    "10",
+            quantity: "30",
+            asks:     []level{{"10", "40", 2}},
+            first:    "s2",
+        },
+        {
+            name:     "changing the price loses priority",
+            price:    "11",
+            quantity: "10",
+            asks:     []level{{"10", "10", 1}, {"11", "10", 1}},
+            first:    "s2",
+        },
+        {
+            name:     "amending through the opposite side trades",
+            price:    "9",
+            quantity: "10",
+            fills:    []fill{{"s1", "b1", "9", "5"}},
+            asks:     []level{{"9", "5", 1}, {"10", "10", 1}},
+            first:    "s1",
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        b.Submit(limit("b1", Buy, "9", "5"))
+        b.Submit(limit("s1", Sell, "10", "10"))
+        b.Submit(limit("s2", Sell, "10", "10"))
+
+        actual, err := b.Amend("s1", d(test.price), d(test.quantity))
+        if err != nil {
+            t.Errorf("%v: unexpected error %v", test.name, err)
+            continue
+        }
+
+        if f := fills(actual); !reflect.DeepEqual(f, test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, f, test.fills)
+        }
+        if l := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(l, test.asks) {
+            t.Errorf("%v: asks %v, expected %v", test.name, l, test.asks)
+        }
+        if first := b.Orders(Sell)[0].ID; first != test.first {
+            t.Errorf("%v: first ask %v, expected %v", test.name, first, test.first)
+        }
+    }
+
+    if _, err := New().Amend("missing", d("10"), d("10")); err != ErrUnknownOrder {
+        t.Errorf("amend of an unknown order: error %v, expected %v", err, ErrUnknownOrder)
+    }
+}
+
+func TestDepth(t *testing.T) {
+    b := New()
+    b.Submit(limit("b1", Buy, "10", "10"))
+    b.Submit(limit("b2", Buy, "10", "15"))
+    b.Submit(limit("b3", Buy, "9", "20"))
+    b.Submit(limit("b4", Buy, "8", "30"))
+
+    tests := []struct {
+        levels   int
+        expected []level
+    }{
+        {0, []level{{"10", "25", 2}, {"9", "20", 1}, {"8", "30", 1}}},
+        {1, []level{{"10", "25", 2}}},
+        {2, []level{{"10", "25", 2}, {"9", "20", 1}}},
+        {5, []level{{"10", "25", 2}, {"9", "20", 1}, {"8", "30", 1}}},
+    }
+
+    for _, test := range tests {
+        if l := levels(b.Depth(Buy, test.levels)); !reflect.DeepEqual(l, test.expected) {
+            t.Errorf("depth %v: %v, expected %v", test.levels, l, test.expected)
+        }
+    }
+
+    if l := b.Depth(Sell, 0); len(l) != 0 {
+        t.Errorf("empty side has depth %v", l)
+    }
+}
+
+func TestAvailable(t *testing.T) {
+    b := New()
+    b.Submit(limit("s1", Sell, "10", "10"))
+    b.Submit(limit("s2", Sell, "11", "20"))
+
+    tests := []struct {
+        order    Order
+        expected string
+    }{
+        {limit("b1", Buy, "9", "100"), "0"},
+        {limit("b1", Buy, "10", "100"), "10"},
+        {limit("b1", Buy, "11", "100"), "30"},
+        {market("b1", Buy, "100"), "30"},
+        {market("s1", Sell, "100"), "0"},
+    }
+
+    for _, test := range tests {
+        if a := b.Available(test.order).String(); a != test.expected {
+            t.Errorf("available to %+v: %v, expected %v", test.order, a, test.expected)
+        }
+    }
+}
+
+func TestFIFO(t *testing.T) {
+    b := New()
+    b.Submit(limit("s1", Sell, "10", "10"))
+    b.Submit(limit("s2", Sell, "11", "10"))
+    b.Submit(limit("s3", Sell, "10", "10"))
+    b.Submit(limit("s4", Sell, "10", "10"))
+    b.Submit(limit("s5", Sell, "11", "10"))
+    b.Cancel("s3")
+    b.Amend("s1", d("10"), d("30"))
+
+    var ids []string
+    for _, o := range b.Orders(Sell) {
+        ids = append(ids, o.ID)
+    }
+
+    expected := []string{"s4", "s1", "s2", "s5"}
+    if !reflect.DeepEqual(ids, expected) {
+        t.Fatalf("queue %v, expected %v", ids, expected)
+    }
+
+    actual, _ := b.Submit(market("b1", Buy, "45"))
+    expectedFills := []fill{{"b1", "s4", "10", "10"}, {"b1", "s1", "10", "30"}, {"b1", "s2", "11", "5"}}
+    if f := fills(actual); !reflect.DeepEqual(f, expectedFills) {
+        t.Errorf("fills %v, expected %v", f, expectedFills)
+    }
+}
+
+func TestSequence(t *testing.T) {
+    b := New()
+    b.Submit(limit("b1", Buy, "10", "10"))
+    b.Submit(limit("b2", Buy, "9", "10"))
+    b.Submit(limit("b3", Buy, "10", "10"))
+
+    first, _ := b.Get("b1")
+    b.Amend("b1", d("10"), d("5"))
+    if o, _ := b.Get("b1"); o.Sequence != first.Sequence {
+        t.Errorf("reduced order sequence %v, expected %v", o.Sequence, first.Sequence)
+    }
+
+    last, _ := b.Get("b3")
+    b.Amend("b1", d("10"), d("20"))
+    if o, _ := b.Get("b1"); o.Sequence <= last.Sequence {
+        t.Errorf("increased order sequence %v, expected it after %v", o.Sequence, last.Sequence)
+    }
+}
+
+//TestPriority checks a large random book against a plain sort by price then sequence
+func TestPriority(t *testing.T) {
+    random := rand.New(rand.NewSource(7))
+    b := New()
+
+    for i := 0; i < 2000; i++ {
+        id := strconv.Itoa(i)
+        switch random.Intn(4) {
+        case 0:
+            b.Cancel(strconv.Itoa(random.Intn(i + 1)))
+        default:
+            b.Submit(limit(id, Side(random.Intn(2)), strconv.Itoa(90+random.Intn(20)), strconv.Itoa(1+random.Intn(50))))
+        }
+    }
+
+    for _, side := range []Side{Buy, Sell} {
+        orders := b.Orders(side)
+        expected := make([]Order, len(orders))
+        copy(expected, orders)
+
+        sort.SliceStable(expected, func(i, j int) bool {
+            if c := expected[i].Price.Cmp(expected[j].Price); c != 0 {
+                return (side == Buy) == (c > 0)
+            }
+            return expected[i].Sequence < expected[j].Sequence
+        })
+
+        if !reflect.DeepEqual(orders, expected) {
+            t.Errorf("side %v is not in price-time priority", side)
+        }
+
+        total := decimal.Zero
+        for _, level := range b.Depth(side, 0) {
+            total = total.Add(level.Quantity)
+        }
+        for _, o := range orders {
+            total = total.Sub(o.Quantity)
+        }
+        if !total.Equals(decimal.Zero) {
+            t.Errorf("side %v depth does not add up to its orders, off by %v", side, total)
+        }
+    }
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+    owned := func(o Order, owner string) Order {
+        o.Owner = owner
+        return o
+    }
+    preventing := func(o Order, prevention Prevention) Order {
+        o.Owner = "A"
+        o.Prevention = prevention
+        return o
+    }
+
+    //prevented is a SelfTrade fill, written as strings
+    type prevented struct {
+        aggressor, resting                 string
+        restingQuantity, aggressorQuantity string
+    }
+
+    book := []Order{owned(limit("s1", Sell, "10", "30"), "A"), owned(limit("s2", Sell, "10", "50"), "B"), owned(limit("s3", Sell, "11", "100"), "A")}
+
+    tests := []struct {
+        name      string
+        order     Order
+        fills     []fill
+        prevented []prevented
+        bids      []level
+        asks      []level
+    }{
+        {
+            name:  "orders without a prevention trade with their owner",
+            order: owned(limit("b1", Buy, "10", "40"), "A"),
+            fills: []fill{{"b1", "s1", "10", "30"}, {"b1", "s2", "10", "10"}},
+            asks:  []level{{"10", "40", 1}, {"11", "100", 1}},
+        },
+        {
+            name:      "cancel resting goes on matching",
+            order:     preventing(limit("b1", Buy, "10", "40"), CancelResting),
+            fills:     []fill{{"b1", "s2", "10", "40"}},
+            prevented: []prevented{{"b1", "s1", "30", "0"}},
+            asks:      []level{{"10", "10", 1}, {"11", "100", 1}},
+        },
+        {
+            name:      "cancel aggressor drops the incoming order",
+            order:     preventing(limit("b1", Buy, "10", "40"), CancelAggressor),
+            prevented: []prevented{{"b1", "s1", "0", "40"}},
+            asks:      []level{{"10", "80", 2}, {"11", "100", 1}},
+        },
+        {
+            name:      "cancel both",
+            order:     preventing(limit("b1", Buy, "10", "40"), CancelBoth),
+            prevented: []prevented{{"b1", "s1", "30", "40"}},
+            asks:      []level{{"10", "50", 1}, {"11", "100", 1}},
+        },
+        {
+            name:      "decrement and cancel the smaller resting order",
+            order:     preventing(limit("b1", Buy, "11", "200"), DecrementAndCancel),
+            fills:     []fill{{"b1", "s2", "10", "50"}},
+            prevented: []prevented{{"b1", "s1", "30", "30"}, {"b1", "s3", "100", "100"}},
+            bids:      []level{{"11", "20", 1}},
+        },
+        {
+            name:      "decrement and cancel the smaller incoming order",
+            order:     preventing(limit("b1", Buy, "10", "20"), DecrementAndCancel),
+            prevented: []prevented{{"b1", "s1", "20", "20"}},
+            asks:      []level{{"10", "60", 2}, {"11", "100", 1}},
+        },
+        {
+            name:  "other owners trade",
+            order: preventing(limit("b1", Buy, "9", "20"), CancelBoth),
+            bids:  []level{{"9", "20", 1}},
+            asks:  []level{{"10", "80", 2}, {"11", "100", 1}},
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        for _, o := range book {
+            b.Submit(o)
+        }
+
+        actual, err := b.Submit(test.order)
+        if err != nil {
+            t.Fatalf("%v: %v", test.name, err)
+        }
+
+        var traded []Fill
+        var selfTrades []prevented
+        for _, f := range actual {
+            if f.SelfTrade {
+                selfTrades = append(selfTrades, prevented{f.Aggressor, f.Resting, f.Quantity.String(), f.AggressorQuantity.String()})
+            } else {
+                traded = append(traded, f)
+            }
+        }
+
+        if !reflect.DeepEqual(fills(traded), test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, fills(traded), test.fills)
+        }
+        if !reflect.DeepEqual(selfTrades, test.prevented) {
+            t.Errorf("%v: prevented %v, expected %v", test.name, selfTrades, test.prevented)
+        }
+        if !reflect.DeepEqual(levels(b.Depth(Buy, 0)), test.bids) {
+            t.Errorf("%v: bids %v, expected %v", test.name, levels(b.Depth(Buy, 0)), test.bids)
+        }
+        if !reflect.DeepEqual(levels(b.Depth(Sell, 0)), test.asks) {
+            t.Errorf("%v: asks %v, expected %v", test.name, levels(b.Depth(Sell, 0)), test.asks)
+        }
+        if trades, _ := b.TradesSince(0); len(trades) != len(traded) {
+            t.Errorf("%v: %v trades recorded, expected %v", test.name, len(trades), len(traded))
+        }
+    }
+
+    //what is available leaves out the orders of the owner prevention keeps the incoming order from trading with
+    availability := []struct {
+        prevention Prevention
+        quantity   string
+        available  string
+    }{
+        {AllowSelfTrade, "200", "180"},
+        {CancelResting, "200", "50"},
+        {CancelAggressor, "200", "0"},
+        {CancelBoth, "200", "0"},
+        {DecrementAndCancel, "200", "50"},
+        {DecrementAndCancel, "60", "30"},
+    }
+    for _, test := range availability {
+        b := New()
+        for _, o := range book {
+            b.Submit(o)
+        }
+
+        if available := b.Available(preventing(limit("b1", Buy, "11", test.quantity), test.prevention)); available.String() != test.available {
+            t.Errorf("prevention %v for %v: available %v, expected %v", test.prevention, test.quantity, available, test.available)
+        }
+    }
+
+    //an all or none order the book can only fill with orders of its owner does not trade
+    b := New()
+    for _, o := range book {
+        b.Submit(o)
+    }
+    allOrNone := preventing(limit("b1", Buy, "11", "80"), CancelResting)
+    allOrNone.AllOrNone = true
+    if actual, err := b.Submit(allOrNone); err != nil || len(actual) != 0 {
+        t.Errorf("all or none against its owner: fills %v, error %v", actual, err)
+    }
+    if !reflect.DeepEqual(levels(b.Depth(Sell, 0)), []level{{"10", "80", 2}, {"11", "100", 1}}) {
+        t.Errorf("all or none against its owner: asks %v", levels(b.Depth(Sell, 0)))
+    }
+}
+
+func TestIceberg(t *testing.T) {
+    iceberg := limit("s1", Sell, "10", "100")
+    iceberg.Display = d("20")
+
+    tests := []struct {
+        name   string
+        orders []Order
+        fills  []fill
+        asks   []level
+        queue  []string
+    }{
+        {
+            name:  "only the display quantity is shown",
+            asks:  []level{{"10", "70", 2}},
+            queue: []string{"s1", "s2"},
+        },
+        {
+            name:   "a slice that fills goes behind the orders at its price",
+            orders: []Order{limit("b1", Buy, "10", "30")},
+            fills:  []fill{{"b1", "s1", "10", "20"}, {"b1", "s2", "10", "10"}},
+            asks:   []level{{"10", "60", 2}},
+            queue:  []string{"s2", "s1"},
+        },
+        {
+            name:   "a partly filled slice keeps its place",
+            orders: []Order{limit("b1", Buy, "10", "5")},
+            fills:  []fill{{"b1", "s1", "10", "5"}},
+            asks:   []level{{"10", "65", 2}},
+            queue:  []string{"s1", "s2"},
+        },
+        {
+            name:   "the reserve trades slice by slice",
+            orders: []Order{limit("b1", Buy, "10", "130")},
+            fills:  []fill{{"b1", "s1", "10", "20"}, {"b1", "s2", "10", "50"}, {"b1", "s1", "10", "20"}, {"b1", "s1", "10", "20"}, {"b1", "s1", "10", "20"}},
+            asks:   []level{{"10", "20", 1}},
+            queue:  []string{"s1"},
+        },
+        {
+            name:   "the last slice is what is left",
+            orders: []Order{limit("b1", Buy, "10", "145")},
+            fills:  []fill{{"b1", "s1", "10", "20"}, {"b1", "s2", "10", "50"}, {"b1", "s1", "10", "20"}, {"b1", "s1", "10", "20"}, {"b1", "s1", "10", "20"}, {"b1", "s1", "10", "15"}},
+            asks:   []level{{"10", "5", 1}},
+            queue:  []string{"s1"},
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        b.Submit(iceberg)
+        b.Submit(limit("s2", Sell, "10", "50"))
+
+        if available := b.Available(limit("b0", Buy, "10", "1000")); !available.Equals(d("150")) {
+            t.Errorf("%v: %v available, expected the reserve to count", test.name, available)
+        }
+
+        var actual []Fill
+        for _, o := range test.orders {
+            f, err := b.Submit(o)
+            if err != nil {
+                t.Fatalf("%v: %v", test.name, err)
+            }
+            actual = append(actual, f...)
+        }
+
+        if !reflect.DeepEqual(fills(actual), test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, fills(actual), test.fills)
+        }
+        if !reflect.DeepEqual(levels(b.Depth(Sell, 0)), test.asks) {
+            t.Errorf("%v: asks %v, expected %v", test.name, levels(b.Depth(Sell, 0)), test.asks)
+        }
+
+        var queue []string
+        for _, o := range b.Orders(Sell) {
+            queue = append(queue, o.ID)
+        }
+        if !reflect.DeepEqual(queue, test.queue) {
+            t.Errorf("%v: queue %v, expected %v", test.name, queue, test.queue)
+        }
+    }
+}
+
+func TestIcebergAmendAndReduce(t *testing.T) {
+    b := New()
+    iceberg := limit("s1", Sell, "10", "100")
+    iceberg.Display = d("20")
+    b.Submit(iceberg)
+    b.Submit(limit("s2", Sell, "10", "50"))
+
+    //reducing an iceberg takes from its reserve and keeps its place
+    b.Amend("s1", d("10"), d("30"))
+    if depth := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(depth, []level{{"10", "70", 2}}) {
+        t.Errorf("after the amend the asks are %v", depth)
+    }
+    if available := b.Available(limit("b0", Buy, "10", "1000")); !available.Equals(d("80")) {
+        t.Errorf("after the amend %v are available, expected 80", available)
+    }
+
+    //a reduction as large as the slice shows the next one behind s2
+    b.Reduce("s1", d("20"))
+    if orders := b.Orders(Sell); orders[0].ID != "s2" || orders[1].ID != "s1" || !orders[1].Quantity.Equals(d("10")) {
+        t.Errorf("after the reduction the asks are %+v", orders)
+    }
+    if depth := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(depth, []level{{"10", "60", 2}}) {
+        t.Errorf("after the reduction the asks are %v", depth)
+    }
+}
+
+func TestMinQuantityAndAllOrNone(t *testing.T) {
+    withMin := func(o Order, min string) Order {
+        o.MinQuantity = d(min)
+        return o
+    }
+    allOrNone := func(o Order) Order {
+        o.AllOrNone = true
+        return o
+    }
+
+    tests := []struct {
+        name  string
+        book  []Order
+        order Order
+        err   error
+        fills []fill
+        bids  []level
+        asks  []level
+    }{
+        {
+            name:  "minimum available",
+            book:  []Order{limit("s1", Sell, "10", "30"), limit("s2", Sell, "11", "30")},
+            order: withMin(limit("b1", Buy, "11", "100"), "60"),
+            fills: []fill{{"b1", "s1", "10", "30"}, {"b1", "s2", "11", "30"}},
+            bids:  []level{{"11", "40", 1}},
+        },
+        {
+            name:  "minimum not available leaves the book untouched",
+            book:  []Order{limit("s1", Sell, "10", "30"), limit("s2", Sell, "11", "30")},
+            order: withMin(limit("b1", Buy, "10", "100"), "31"),
+            err:   ErrMinQuantity,
+            asks:  []level{{"10", "30", 1}, {"11", "30", 1}},
+        },
+        {
+            name:  "a resting order no longer has a minimum",
+            book:  []Order{limit("s0", Sell, "10", "60"), withMin(limit("b1", Buy, "10", "100"), "50")},
+            order: limit("s1", Sell, "10", "10"),
+            fills: []fill{{"s1", "b1", "10", "10"}},
+            bids:  []level{{"10", "30", 1}},
+        },
+        {
+            name:  "all or none fills entirely",
+            book:  []Order{limit("s1", Sell, "10", "30"), limit("s2", Sell, "11", "30")},
+            order: allOrNone(limit("b1", Buy, "11", "60")),
+            fills: []fill{{"b1", "s1", "10", "30"}, {"b1", "s2", "11", "30"}},
+        },
+        {
+            name:  "all or none that cannot fill entirely rests without trading",
+            book:  []Order{limit("s1", Sell, "10", "30")},
+            order: allOrNone(limit("b1", Buy, "10", "60")),
+            asks:  []level{{"10", "30", 1}},
+        },
+        {
+            name:  "immediate all or none that cannot fill entirely is dropped",
+            book:  []Order{limit("s1", Sell, "10", "30")},
+            order: allOrNone(market("b1", Buy, "60")),
+            err:   ErrMinQuantity,
+            asks:  []level{{"10", "30", 1}},
+        },
+        {
+            name:  "resting all or none is passed over by smaller orders",
+            book:  []Order{allOrNone(limit("s1", Sell, "10", "50")), limit("s2", Sell, "10", "20"), limit("s3", Sell, "11", "20")},
+            order: limit("b1", Buy, "11", "30"),
+            fills: []fill{{"b1", "s2", "10", "20"}, {"b1", "s3", "11", "10"}},
+            asks:  []level{{"11", "10", 1}},
+        },
+        {
+            name:  "resting all or none trades entirely with a larger order",
+            book:  []Order{allOrNone(limit("s1", Sell, "10", "50")), limit("s2", Sell, "10", "20")},
+            order: limit("b1", Buy, "10", "60"),
+            fills: []fill{{"b1", "s1", "10", "50"}, {"b1", "s2", "10", "10"}},
+            asks:  []level{{"10", "10", 1}},
+        },
+        {
+            name:  "resting all or none counts only for orders large enough",
+            book:  []Order{allOrNone(limit("s1", Sell, "10", "50")), limit("s2", Sell, "10", "20")},
+            order: withMin(limit("b1", Buy, "10", "40"), "21"),
+            err:   ErrMinQuantity,
+            asks:  []level{{"10", "20", 1}},
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        for _, o := range test.book {
+            if _, err := b.Submit(o); err != nil {
+                t.Fatalf("%v: %v", test.name, err)
+            }
+        }
+
+        actual, err := b.Submit(test.order)
+        if err != test.err {
+            t.Errorf("%v: error %v, expected %v", test.name, err, test.err)
+        }
+        if !reflect.DeepEqual(fills(actual), test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, fills(actual), test.fills)
+        }
+        if !reflect.DeepEqual(levels(b.Depth(Buy, 0)), test.bids) {
+            t.Errorf("%v: bids %v, expected %v", test.name, levels(b.Depth(Buy, 0)), test.bids)
+        }
+        if !reflect.DeepEqual(levels(b.Depth(Sell, 0)), test.asks) {
+            t.Errorf("%v: asks %v, expected %v", test.name, levels(b.Depth(Sell, 0)), test.asks)
+        }
+    }
+}
+
+func TestDiscretion(t *testing.T) {
+    discretionary := limit("s1", Sell, "10.05", "100")
+    discretionary.Discretion = d("0.05")
+
+    tests := []struct {
+        name  string
+        order Order
+        fills []fill
+        bids  []level
+    }{
+        {
+            name:  "an order within the discretion trades at its own price",
+            order: limit("b1", Buy, "10.02", "30"),
+            fills: []fill{{"b1", "s1", "10.02", "30"}},
+        },
+        {
+            name:  "an order out of the discretion rests",
+            order: limit("b1", Buy, "9.99", "30"),
+            bids:  []level{{"9.99", "30", 1}},
+        },
+        {
+            name:  "the displayed price still trades first",
+            order: limit("b1", Buy, "10.05", "30"),
+            fills: []fill{{"b1", "s1", "10.05", "30"}},
+        },
+        {
+            name:  "what the discretion does not fill rests",
+            order: limit("b1", Buy, "10", "150"),
+            fills: []fill{{"b1", "s1", "10", "100"}},
+            bids:  []level{{"10", "50", 1}},
+        },
+    }
+
+    for _, test := range tests {
+        b := New()
+        b.Submit(discretionary)
+
+        if asks := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(asks, []level{{"10.05", "100", 1}}) {
+            t.Errorf("%v: asks %v, expected the order to show at its price", test.name, asks)
+        }
+
+        actual, err := b.Submit(test.order)
+        if err != nil {
+            t.Fatalf("%v: %v", test.name, err)
+        }
+        if !reflect.DeepEqual(fills(actual), test.fills) {
+            t.Errorf("%v: fills %v, expected %v", test.name, fills(actual), test.fills)
+        }
+        if !reflect.DeepEqual(levels(b.Depth(Buy, 0)), test.bids) {
+            t.Errorf("%v: bids %v, expected %v", test.name, levels(b.Depth(Buy, 0)), test.bids)
+        }
+    }
+}
+
+func TestBest(t *testing.T) {
+    b := New()
+    pegged := limit("b1", Buy, "10.02", "10")
+    pegged.Pegged = true
+    b.Submit(pegged)
+
+    if _, ok := b.Best(Buy); ok {
+        t.Errorf("a pegged order alone should leave no best bid")
+    }
+
+    b.Submit(limit("b2", Buy, "10", "10"))
+    if best, ok := b.Best(Buy); !ok || !best.Equals(d("10")) {
+        t.Errorf("best bid %v, expected 10", best)
+    }
+
+    b.Submit(limit("b3", Buy, "10.02", "10"))
+    if best, ok := b.Best(Buy); !ok || !best.Equals(d("10.02")) {
+        t.Errorf("best bid %v, expected 10.02 once an order that is not pegged is there", best)
+    }
+}
+
+func TestAllOrNoneNotShown(t *testing.T) {
+    allOrNone := limit("s1", Sell, "10", "50")
+    allOrNone.AllOrNone = true
+
+    //the all or none ask crosses the smaller bid without trading with it, the book must not look crossed
+    b := New()
+    b.Submit(allOrNone)
+    if fills, _ := b.Submit(limit("b1", Buy, "10", "20")); len(fills) != 0 {
+        t.Fatalf("all or none traded with a smaller order: %v", fills)
+    }
+
+    if best, ok := b.Best(Sell); ok {
+        t.Errorf("best ask %v, expected none", best)
+    }
+    if asks := levels(b.Depth(Sell, 0)); len(asks) != 0 {
+        t.Errorf("asks %v, expected none", asks)
+    }
+    if bids := levels(b.Depth(Buy, 0)); !reflect.DeepEqual(bids, []level{{"10", "20", 1}}) {
+        t.Errorf("bids %v, expected 20 at 10", bids)
+    }
+
+    //next to orders that are shown, the level shows only them
+    b.Submit(limit("s2", Sell, "11", "5"))
+    b.Submit(limit("s3", Sell, "11", "7"))
+    s4 := limit("s4", Sell, "11", "30")
+    s4.AllOrNone = true
+    b.Submit(s4)
+    if best, ok := b.Best(Sell); !ok || !best.Equals(d("11")) {
+        t.Errorf("best ask %v, expected 11", best)
+    }
+    if asks := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(asks, []level{{"11", "12", 2}}) {
+        t.Errorf("asks %v, expected 12 at 11", asks)
+    }
+
+    //once it trades it is gone from the count of its level
+    b.Submit(limit("b2", Buy, "10", "50"))
+    b.Cancel("b1")
+    b.Cancel("s2")
+    b.Cancel("s3")
+    if _, ok := b.Best(Sell); ok {
+        t.Errorf("an ask is left after every shown one is gone")
+    }
+    b.Submit(limit("s5", Sell, "10", "1"))
+    if asks := levels(b.Depth(Sell, 0)); !reflect.DeepEqual(asks, []level{{"10", "1", 1}}) {
+        t.Errorf("asks %v, expected 1 at 10", asks)
+    }
+}
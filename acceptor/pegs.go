@@ -0,0 +1,149 @@
+package main
+
+import (
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+
+    "github.com/btasdoven/quickfixwebclient/acceptor/orderbook"
+)
+
+//pegInsts are the ExecInst a pegged order may follow the market with
+var pegInsts = []enum.ExecInst{enum.ExecInst_PRIMARY_PEG, enum.ExecInst_MARKET_PEG, enum.ExecInst_MID_PRICE_PEG}
+
+//isPegged reports whether order follows the market instead of resting at a price of its own
+func (o *Order) isPegged() bool {
+    return o.OrdType == enum.OrdType_PEGGED
+}
+
+//peg returns the ExecInst order is pegged with, "" if it has none
+func (o *Order) peg() enum.ExecInst {
+    for _, inst := range pegInsts {
+        if o.hasExecInst(inst) {
+            return inst
+        }
+    }
+    return ""
+}
+
+//bookPrice is the price order rests at, for a pegged order the price it is pegged to
+func (o *Order) bookPrice() decimal.Decimal {
+    if o.isPegged() {
+        return o.PeggedPrice
+    }
+    return o.Price
+}
+
+//pegPrice returns the price order is pegged to in the book of stock, false while what it follows is missing.
+//A primary peg follows the best price of its own side, a market peg the best price of the other side and a
+//mid-price peg the middle of both, PegDifference is added to it. Pegged orders are left out of the prices
+//followed, so pegs never chase each other or themselves.
+func (q *Quote) pegPrice(order *Order) (decimal.Decimal, bool) {
+    bid, hasBid := q.book.Best(orderbook.Buy)
+    ask, hasAsk := q.book.Best(orderbook.Sell)
+
+    same, hasSame, opposite, hasOpposite := bid, hasBid, ask, hasAsk
+    if order.Side != enum.Side_BUY {
+        same, hasSame, opposite, hasOpposite = ask, hasAsk, bid, hasBid
+    }
+
+    var price decimal.Decimal
+    switch order.peg() {
+    case enum.ExecInst_PRIMARY_PEG:
+        if !hasSame {
+            return decimal.Zero, false
+        }
+        price = same
+    case enum.ExecInst_MARKET_PEG:
+        if !hasOpposite {
+            return decimal.Zero, false
+        }
+        price = opposite
+    case enum.ExecInst_MID_PRICE_PEG:
+        if !hasBid || !hasAsk {
+            return decimal.Zero, false
+        }
+        price = bid.Add(ask).Div(decimal.New(2, 0))
+    default:
+        return decimal.Zero, false
+    }
+    price = price.Add(order.PegDifference)
+
+    //the Price of a pegged order is its limit, it is never pegged past it
+    if order.Price.Cmp(decimal.Zero) > 0 {
+        if order.Side == enum.Side_BUY {
+            price = decimal.Min(price, order.Price)
+        } else {
+            price = decimal.Max(price, order.Price)
+        }
+    }
+    return price, price.Cmp(decimal.Zero) > 0
+}
+
+//holdPeg acknowledges a pegged order that has nothing to follow yet, it reaches the book once it has a price.
+//An immediate order cannot wait for one and is cancelled.
+func (e *executor) holdPeg(order *Order) {
+    e.acknowledge(order)
+
+    if order.isImmediate() {
+        e.cancel(order)
+        order.Text = "No price to peg to"
+        e.send(newExecutionReport(order), order.SessionID)
+    }
+}
+
+//repeg moves every pegged order of stock to the price it is pegged to. A peg keeps its time priority for as
+//long as that price holds, one that moves goes behind the orders at its new price like any amended order.
+//Moving a peg may trade and move the prices followed again, so it runs until every peg is in place.
+func (e *executor) repeg(stock *Quote) {
+    for moved := true; moved; {
+        moved = false
+
+        working := e.pegs[stock.symbol][:0]
+        for _, order := range e.pegs[stock.symbol] {
+            if !order.isWorking() {
+                continue
+            }
+            working = append(working, order)
+
+            if price, ok := stock.pegPrice(order); ok && !price.Equals(order.PeggedPrice) {
+                e.movePeg(stock, order, price)
+                moved = true
+            }
+        }
+        e.pegs[stock.symbol] = working
+
+        if moved {
+            e.triggerStops(stock)
+        }
+    }
+}
+
+//movePeg restates order at price and matches it there. A peg that had nothing to follow on arrival reaches
+//the book for the first time.
+func (e *executor) movePeg(stock *Quote, order *Order, price decimal.Decimal) {
+    e.printf("[SERVER]: Peg %v moves from %v to %v\n", order.ClOrdID, order.PeggedPrice, price)
+
+    order.PeggedPrice = price
+    order.LastPrice = decimal.Zero
+    order.LastShares = decimal.Zero
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_RESTATED
+    order.ExecID = e.genExecID().Value()
+
+    e.record(eventRestate, order)
+    e.send(newExecutionReport(order), order.SessionID)
+
+    if _, ok := e.resting[order.OrderID]; !ok {
+        e.execute(stock, order)
+        return
+    }
+
+    fills, _ := stock.book.Amend(order.OrderID, price, order.LeavesQty)
+    e.report(stock, order, fills)
+
+    if !order.isWorking() {
+        delete(e.resting, order.OrderID)
+    } else {
+        e.record(eventRest, order)
+    }
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "path"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/shopspring/decimal"
+
+    "github.com/btasdoven/go-finance"
+)
+
+const (
+    //PriceSourceSetting selects where reference prices come from: finance, file or static. finance if not set
+    PriceSourceSetting string = "PriceSource"
+    //PriceFile is the csv or json seed file read by the file price source
+    PriceFile string = "PriceFile"
+    //PriceTimeout is how long the finance price source waits for a quote, e.g. 500ms. 2s if not set
+    PriceTimeout string = "PriceTimeout"
+)
+
+//pricesSection is the acceptor.cfg section holding the prices of the static price source
+const pricesSection = "PRICES"
+
+//ReferencePrice is the market a symbol's book is seeded with
+type ReferencePrice struct {
+    Last     decimal.Decimal
+    LastSize decimal.Decimal
+    Bid      decimal.Decimal
+    BidSize  decimal.Decimal
+    Ask      decimal.Decimal
+    AskSize  decimal.Decimal
+}
+
+//PriceSource looks up the reference price of a symbol
+type PriceSource interface {
+    GetPrice(symbol string) (ReferencePrice, error)
+}
+
+//UnknownSymbol is returned by a PriceSource that has no price for Symbol
+type UnknownSymbol struct {
+    Symbol string
+}
+
+func (e UnknownSymbol) Error() string {
+    return fmt.Sprintf("unknown symbol %v", e.Symbol)
+}
+
+//financeSource fetches prices from the go-finance web service. Quotes are looked up under the lock of the
+//executor, so a lookup gives up after timeout. Its answer is cached whenever it comes, unless it failed.
+type financeSource struct {
+    timeout  time.Duration
+    getQuote func(symbol string) (*finance.Quote, error)
+
+    lock   sync.Mutex
+    prices map[string]financePrice
+}
+
+//financePrice is the answer of the web service for one symbol
+type financePrice struct {
+    price ReferencePrice
+    err   error
+}
+
+func newFinanceSource(timeout time.Duration) *financeSource {
+    return &financeSource{timeout: timeout, getQuote: finance.GetQuote, prices: make(map[string]financePrice)}
+}
+
+func (s *financeSource) GetPrice(symbol string) (ReferencePrice, error) {
+    s.lock.Lock()
+    cached, ok := s.prices[symbol]
+    s.lock.Unlock()
+    if ok {
+        return cached.price, cached.err
+    }
+
+    answer := make(chan financePrice, 1)
+    go func() {
+        var p financePrice
+        p.price, p.err = s.fetch(symbol)
+
+        //a lookup that failed is tried again by the next order
+        if _, unknown := p.err.(UnknownSymbol); p.err == nil || unknown {
+            s.lock.Lock()
+            s.prices[symbol] = p
+            s.lock.Unlock()
+        }
+        answer <- p
+    }()
+
+    select {
+    case p := <-answer:
+        return p.price, p.err
+    case <-time.After(s.timeout):
+        return ReferencePrice{}, fmt.Errorf("no quote for %v within %v", symbol, s.timeout)
+    }
+}
+
+//fetch asks the web service for the quote of symbol. go-finance panics on an empty or short answer and quotes
+//symbols it does not know at zero, both mean the symbol is unknown.
+func (s *financeSource) fetch(symbol string) (price ReferencePrice, err error) {
+    defer func() {
+        if recover() != nil {
+            price, err = ReferencePrice{}, UnknownSymbol{Symbol: symbol}
+        }
+    }()
+
+    stock, err := s.getQuote(symbol)
+    if err != nil {
+        return
+    }
+    if stock == nil || stock.LastTradePrice.Cmp(decimal.Zero) <= 0 {
+        return price, UnknownSymbol{Symbol: symbol}
+    }
+
+    price.Last = stock.LastTradePrice
+    price.LastSize = decimal.New(int64(stock.LastTradeSize), 0)
+    price.Bid = stock.Bid
+    price.BidSize = decimal.New(int64(stock.BidSize), 0)
+    price.Ask = stock.Ask
+    price.AskSize = decimal.New(int64(stock.AskSize), 0)
+    return
+}
+
+//staticSource serves a fixed set of prices loaded at startup
+type staticSource map[string]ReferencePrice
+
+func (s staticSource) GetPrice(symbol string) (ReferencePrice, error) {
+    price, ok := s[symbol]
+    if !ok {
+        return price, UnknownSymbol{Symbol: symbol}
+    }
+    return price, nil
+}
+
+//parsePrice reads a price given as Last,LastSize,Bid,BidSize,Ask,AskSize
+func parsePrice(values []string) (price ReferencePrice, err error) {
+    if len(values) != 6 {
+        return price, fmt.Errorf("expected Last,LastSize,Bid,BidSize,Ask,AskSize, got %v values", len(values))
+    }
+
+    fields := []*decimal.Decimal{&price.Last, &price.LastSize, &price.Bid, &price.BidSize, &price.Ask, &price.AskSize}
+    for i, value := range values {
+        if *fields[i], err = decimal.NewFromString(strings.TrimSpace(value)); err != nil {
+            return
+        }
+    }
+    return
+}
+
+//newStaticSource builds a price source from SYMBOL=Last,LastSize,Bid,BidSize,Ask,AskSize settings
+func newStaticSource(settings map[string]string) (staticSource, error) {
+    s := make(staticSource)
+    for symbol, value := range settings {
+        price, err := parsePrice(strings.Split(value, ","))
+        if err != nil {
+            return nil, quickfix.IncorrectFormatForSetting{Setting: symbol, Value: value, Err: err}
+        }
+        s[symbol] = price
+    }
+    return s, nil
+}
+
+//readCSVPrices reads a seed file with a Symbol,Last,LastSize,Bid,BidSize,Ask,AskSize header
+func readCSVPrices(r io.Reader) (staticSource, error) {
+    records, err := csv.NewReader(r).ReadAll()
+    if err != nil {
+        return nil, err
+    }
+
+    s := make(staticSource)
+    for i, record := range records {
+        if i == 0 && strings.EqualFold(record[0], "Symbol") {
+            continue
+        }
+
+        price, err := parsePrice(record[1:])
+        if err != nil {
+            return nil, fmt.Errorf("line %v: %v", i+1, err)
+        }
+        s[record[0]] = price
+    }
+    return s, nil
+}
+
+//readJSONPrices reads a seed file holding an object of ReferencePrice keyed by symbol
+func readJSONPrices(r io.Reader) (staticSource, error) {
+    s := make(staticSource)
+    if err := json.NewDecoder(r).Decode(&s); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+//newFileSource loads every price of a csv or json seed file
+func newFileSource(fileName string) (staticSource, error) {
+    file, err := os.Open(fileName)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    switch strings.ToLower(path.Ext(fileName)) {
+    case ".csv":
+        return readCSVPrices(file)
+    case ".json":
+        return readJSONPrices(file)
+    }
+
+    return nil, fmt.Errorf("%v: price files must be .csv or .json", fileName)
+}
+
+//newPriceSource selects the price source named by the PriceSource setting, prices holds the static section
+func newPriceSource(settings *quickfix.SessionSettings, prices map[string]string) (PriceSource, error) {
+    source := "finance"
+    if settings.HasSetting(PriceSourceSetting) {
+        source, _ = settings.Setting(PriceSourceSetting)
+    }
+
+    switch strings.ToLower(source) {
+    case "finance":
+        timeout := 2 * time.Second
+        if settings.HasSetting(PriceTimeout) {
+            value, _ := settings.Setting(PriceTimeout)
+            var err error
+            if timeout, err = time.ParseDuration(value); err != nil {
+                return nil, quickfix.IncorrectFormatForSetting{Setting: PriceTimeout, Value: value, Err: err}
+            }
+        }
+        return newFinanceSource(timeout), nil
+    case "static":
+        return newStaticSource(prices)
+    case "file":
+        fileName, err := settings.Setting(PriceFile)
+        if err != nil {
+            return nil, err
+        }
+        return newFileSource(fileName)
+    }
+
+    return nil, quickfix.IncorrectFormatForSetting{Setting: PriceSourceSetting, Value: source, Err: fmt.Errorf("expected finance, file or static")}
+}
+
+//splitSection takes the named section out of cfg, which quickfix would refuse to parse, and returns the rest
+func splitSection(cfg io.Reader, name string) (rest io.Reader, section map[string]string, err error) {
+    sectionRegEx := regexp.MustCompile(`^\[(.*)\]\s*$`)
+    settingRegEx := regexp.MustCompile(`^([^=]*)=(.*)$`)
+
+    var buffer bytes.Buffer
+    section = make(map[string]string)
+    inSection := false
+
+    scanner := bufio.NewScanner(cfg)
+    for scanner.Scan() {
+        line := scanner.Text()
+
+        if parts := sectionRegEx.FindStringSubmatch(line); parts != nil {
+            inSection = strings.EqualFold(parts[1], name)
+        }
+
+        if !inSection {
+            buffer.WriteString(line + "\n")
+            continue
+        }
+
+        if strings.HasPrefix(strings.TrimSpace(line), "#") {
+            continue
+        }
+        if parts := settingRegEx.FindStringSubmatch(line); parts != nil {
+            section[strings.TrimSpace(parts[1])] = strings.TrimSpace(parts[2])
+        }
+    }
+
+    if err = scanner.Err(); err != nil {
+        return
+    }
+
+    return &buffer, section, nil
+}
+
+//symbolSettings splits the Symbol.Setting=value entries of the named section into the settings of each symbol
+func symbolSettings(name string, section map[string]string) (map[string]*quickfix.SessionSettings, error) {
+    bySymbol := make(map[string]*quickfix.SessionSettings)
+    for key, value := range section {
+        dot := strings.LastIndex(key, ".")
+        if dot <= 0 {
+            return nil, fmt.Errorf("[%v] %v: expected Symbol.Setting", name, key)
+        }
+
+        symbol := key[:dot]
+        if bySymbol[symbol] == nil {
+            bySymbol[symbol] = quickfix.NewSessionSettings()
+        }
+        bySymbol[symbol].Set(key[dot+1:], value)
+    }
+    return bySymbol, nil
+}
+
+//readSettings parses an acceptor config file, returning the sections quickfix does not know separately by name
+func readSettings(cfgFileName string) (*quickfix.Settings, map[string]map[string]string, error) {
+    cfg, err := ioutil.ReadFile(cfgFileName)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var rest io.Reader = bytes.NewReader(cfg)
+    sections := make(map[string]map[string]string)
+    for _, name := range []string{pricesSection, riskSection, phasesSection, marketMakerSection} {
+        if rest, sections[name], err = splitSection(rest, name); err != nil {
+            return nil, nil, err
+        }
+    }
+
+    settings, err := quickfix.ParseSettings(rest)
+    return settings, sections, err
+}
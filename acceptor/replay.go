@@ -0,0 +1,312 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "os"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/quickfixgo/quickfix/tag"
+    "github.com/shopspring/decimal"
+)
+
+//reportFields are the fields of an execution report compared by a replay. ExecIDs are left out, they count
+//every execution of the acceptor and not only those of the replayed sessions.
+var reportFields = []quickfix.Tag{
+    tag.ClOrdID,
+    tag.OrigClOrdID,
+    tag.OrderID,
+    tag.ExecTransType,
+    tag.ExecType,
+    tag.OrdStatus,
+    tag.OrdRejReason,
+    tag.Symbol,
+    tag.Side,
+    tag.OrderQty,
+    tag.LastShares,
+    tag.LastPx,
+    tag.LeavesQty,
+    tag.CumQty,
+    tag.AvgPx,
+}
+
+//readFIXLog reads every FIX message of a quickfix message log, or of any file holding one message per line.
+//Whatever precedes 8=FIX on a line, such as the timestamp of the log, is skipped and | is accepted in place
+//of SOH.
+func readFIXLog(r io.Reader) (messages []*quickfix.Message, err error) {
+    scanner := bufio.NewScanner(r)
+    scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+    for line := 1; scanner.Scan(); line++ {
+        text := scanner.Text()
+        start := strings.Index(text, "8=FIX")
+        if start < 0 {
+            continue
+        }
+
+        raw := strings.Replace(text[start:], "|", "\001", -1)
+        msg := quickfix.NewMessage()
+        if err = quickfix.ParseMessage(msg, bytes.NewBufferString(raw)); err != nil {
+            return nil, fmt.Errorf("line %v: %v", line, err)
+        }
+        messages = append(messages, msg)
+    }
+
+    return messages, scanner.Err()
+}
+
+//isAdmin reports whether msg belongs to the session layer rather than to the executor
+func isAdmin(msg *quickfix.Message) bool {
+    msgType, _ := msg.Header.GetString(tag.MsgType)
+    switch enum.MsgType(msgType) {
+    case enum.MsgType_HEARTBEAT, enum.MsgType_TEST_REQUEST, enum.MsgType_RESEND_REQUEST, enum.MsgType_REJECT,
+        enum.MsgType_SEQUENCE_RESET, enum.MsgType_LOGOUT, enum.MsgType_LOGON:
+        return true
+    }
+    return false
+}
+
+func isExecutionReport(msg *quickfix.Message) bool {
+    msgType, _ := msg.Header.GetString(tag.MsgType)
+    return msgType == string(enum.MsgType_EXECUTION_REPORT)
+}
+
+//sessionOf returns the session of the acceptor named senderCompID a logged message was exchanged on, and
+//whether the acceptor received it
+func sessionOf(msg *quickfix.Message, senderCompID string) (sessionID quickfix.SessionID, inbound bool) {
+    beginString, _ := msg.Header.GetString(tag.BeginString)
+    sender, _ := msg.Header.GetString(tag.SenderCompID)
+    target, _ := msg.Header.GetString(tag.TargetCompID)
+
+    if target == senderCompID {
+        return quickfix.SessionID{BeginString: beginString, SenderCompID: target, TargetCompID: sender}, true
+    }
+    return quickfix.SessionID{BeginString: beginString, SenderCompID: sender, TargetCompID: target}, false
+}
+
+//useClock makes e take the time from now instead of the wall clock. Market makers and expiry then only move
+//when advance is called.
+func (e *executor) useClock(now func() time.Time) {
+    e.now = now
+    e.manual = true
+}
+
+//advance moves the market makers and the expiry of orders on to now
+func (e *executor) advance(now time.Time) {
+    e.lock.Lock()
+    for _, m := range e.makers {
+        for !m.due.After(now) {
+            m.step()
+            e.refresh(m)
+            m.due = m.due.Add(m.interval)
+        }
+    }
+    e.lock.Unlock()
+
+    e.expireOrders(now)
+}
+
+//replayLog feeds the application messages senderCompID received in messages through e, every one at the
+//time it was sent. It returns the execution reports e sent back and those originally sent, by session.
+func (e *executor) replayLog(messages []*quickfix.Message, senderCompID string) (replayed, original map[quickfix.SessionID][]*quickfix.Message) {
+    replayed = make(map[quickfix.SessionID][]*quickfix.Message)
+    original = make(map[quickfix.SessionID][]*quickfix.Message)
+
+    var clock time.Time
+    e.useClock(func() time.Time { return clock })
+    e.send = func(m quickfix.Messagable, sessionID quickfix.SessionID) error {
+        msg := m.ToMessage()
+        if isExecutionReport(msg) {
+            msg.Header.SetString(tag.SenderCompID, sessionID.SenderCompID)
+            msg.Header.SetString(tag.TargetCompID, sessionID.TargetCompID)
+            replayed[sessionID] = append(replayed[sessionID], msg)
+        }
+        return nil
+    }
+
+    for _, msg := range messages {
+        if isAdmin(msg) {
+            continue
+        }
+
+        sessionID, inbound := sessionOf(msg, senderCompID)
+        if !inbound {
+            if isExecutionReport(msg) {
+                original[sessionID] = append(original[sessionID], msg)
+            }
+            continue
+        }
+
+        if sendingTime, err := msg.Header.GetTime(tag.SendingTime); err == nil && sendingTime.After(clock) {
+            clock = sendingTime
+            e.advance(clock)
+        }
+
+        if reject := e.FromApp(msg, sessionID); reject != nil {
+            fmt.Printf("[REPLAY]: %v rejected: %v\n", msg, reject)
+        }
+    }
+    return
+}
+
+//sameValue compares two values of a field, numbers are equal whatever their precision
+func sameValue(a string, b string) bool {
+    if a == b {
+        return true
+    }
+
+    x, errX := decimal.NewFromString(a)
+    y, errY := decimal.NewFromString(b)
+    return errX == nil && errY == nil && x.Equals(y)
+}
+
+//diffReports describes every difference between the execution reports originally sent to a session and
+//those sent again by the replay
+func diffReports(original []*quickfix.Message, replayed []*quickfix.Message) (diffs []string) {
+    for i := 0; i < len(original) || i < len(replayed); i++ {
+        switch {
+        case i >= len(replayed):
+            diffs = append(diffs, fmt.Sprintf("report %v missing from the replay: %v", i+1, original[i]))
+            continue
+        case i >= len(original):
+            diffs = append(diffs, fmt.Sprintf("report %v only in the replay: %v", i+1, replayed[i]))
+            continue
+        }
+
+        for _, t := range reportFields {
+            was, _ := original[i].Body.GetString(t)
+            is, _ := replayed[i].Body.GetString(t)
+            if !sameValue(was, is) {
+                clOrdID, _ := original[i].Body.GetString(tag.ClOrdID)
+                diffs = append(diffs, fmt.Sprintf("report %v of %v: tag %v was %v, replayed %v", i+1, clOrdID, t, was, is))
+            }
+        }
+    }
+    return
+}
+
+//runReplay replays the log at logFileName through an executor configured by cfgFileName, with the reference
+//prices of priceFileName if given, writes the execution reports of the replay to out and returns how many
+//fields differ from the reports originally sent.
+func runReplay(cfgFileName string, logFileName string, priceFileName string, out io.Writer) (differences int, err error) {
+    appSettings, sections, err := readSettings(cfgFileName)
+    if err != nil {
+        return
+    }
+    settings := appSettings.GlobalSettings()
+
+    var priceSource PriceSource
+    if priceFileName != "" {
+        priceSource, err = newFileSource(priceFileName)
+    } else {
+        priceSource, err = newPriceSource(settings, sections[pricesSection])
+    }
+    if err != nil {
+        return
+    }
+    if _, live := priceSource.(*financeSource); live {
+        fmt.Printf("[REPLAY]: Live prices are not reproducible, give the recorded prices with -prices\n")
+    }
+
+    senderCompID, err := settings.Setting("SenderCompID")
+    if err != nil {
+        return
+    }
+
+    file, err := os.Open(logFileName)
+    if err != nil {
+        return
+    }
+    defer file.Close()
+
+    messages, err := readFIXLog(file)
+    if err != nil {
+        return 0, fmt.Errorf("%v: %v", logFileName, err)
+    }
+
+    e, err := newExecutor(settings, priceSource)
+    if err != nil {
+        return
+    }
+    if e.risk, err = newRiskRules(settings, appSettings.SessionSettings(), sections[riskSection]); err != nil {
+        return
+    }
+    if e.selfTrade, err = newSelfTradeRules(settings, appSettings.SessionSettings()); err != nil {
+        return
+    }
+    if e.phases, err = newPhaseRules(settings, sections[phasesSection], e.marketClose); err != nil {
+        return
+    }
+    if e.marketMaker, err = newMarketMakerRules(settings, sections[marketMakerSection]); err != nil {
+        return
+    }
+
+    //out and the differences are all a replay prints
+    e.log = ioutil.Discard
+
+    replayed, original := e.replayLog(messages, senderCompID)
+
+    var sessions []quickfix.SessionID
+    for sessionID := range replayed {
+        sessions = append(sessions, sessionID)
+    }
+    sort.Slice(sessions, func(i, j int) bool { return sessions[i].String() < sessions[j].String() })
+
+    for _, sessionID := range sessions {
+        reports := replayed[sessionID]
+        for _, report := range reports {
+            fmt.Fprintln(out, strings.Replace(report.String(), "\001", "|", -1))
+        }
+
+        if _, logged := original[sessionID]; !logged {
+            continue
+        }
+        for _, diff := range diffReports(original[sessionID], reports) {
+            fmt.Printf("[REPLAY]: %v %v\n", sessionID, diff)
+            differences++
+        }
+    }
+
+    for sessionID, reports := range original {
+        if _, ok := replayed[sessionID]; !ok {
+            fmt.Printf("[REPLAY]: %v %v reports were not replayed\n", sessionID, len(reports))
+            differences += len(reports)
+        }
+    }
+    return
+}
+
+//replay is the replay command, it returns the exit status of the acceptor: 0 if the replay sent the same
+//execution reports as the log, 1 if it did not and 2 if it could not run
+func replay(cfgFileName string, logFileName string, priceFileName string, outFileName string) int {
+    out := os.Stdout
+    if outFileName != "" {
+        file, err := os.Create(outFileName)
+        if err != nil {
+            fmt.Printf("Unable to create %v: %s\n", outFileName, err)
+            return 2
+        }
+        defer file.Close()
+        out = file
+    }
+
+    differences, err := runReplay(cfgFileName, logFileName, priceFileName, out)
+    if err != nil {
+        fmt.Printf("Unable to replay %v: %s\n", logFileName, err)
+        return 2
+    }
+
+    if differences > 0 {
+        fmt.Printf("[REPLAY]: %v differences to the execution reports of %v\n", differences, logFileName)
+        return 1
+    }
+    fmt.Printf("[REPLAY]: The execution reports of %v were reproduced\n", logFileName)
+    return 0
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+)
+
+const (
+    //MaxOrderQty is the largest OrderQty accepted
+    MaxOrderQty string = "MaxOrderQty"
+    //MaxNotional is the largest OrderQty times price accepted, market orders are valued at the last trade
+    MaxNotional string = "MaxNotional"
+    //PriceBand is how far, in percent, a limit price may be from the last trade of the symbol
+    PriceBand string = "PriceBand"
+    //MaxOpenOrders is the largest number of orders a session may have working at once
+    MaxOpenOrders string = "MaxOpenOrders"
+    //MaxPosition is the largest net position a session may reach in a symbol, counting its working orders as filled
+    MaxPosition string = "MaxPosition"
+)
+
+//riskSection is the acceptor.cfg section holding the risk limits of single symbols, as Symbol.Setting=value
+const riskSection = "RISK"
+
+//riskLimits are the pre-trade limits of a session or a symbol, a zero limit is not checked
+type riskLimits struct {
+    maxOrderQty   decimal.Decimal
+    maxNotional   decimal.Decimal
+    priceBand     decimal.Decimal
+    maxPosition   decimal.Decimal
+    maxOpenOrders int
+}
+
+//riskRules are the limits every order is checked against before it is accepted. Orders of a session are
+//held to its SESSION settings, or to the DEFAULT ones for sessions not configured, and to the limits of
+//their symbol.
+type riskRules struct {
+    defaults riskLimits
+    sessions map[quickfix.SessionID]riskLimits
+    symbols  map[string]riskLimits
+}
+
+func newRiskLimits(settings *quickfix.SessionSettings) (l riskLimits, err error) {
+    if err = decimalSetting(settings, MaxOrderQty, &l.maxOrderQty); err != nil {
+        return
+    }
+    if err = decimalSetting(settings, MaxNotional, &l.maxNotional); err != nil {
+        return
+    }
+    if err = decimalSetting(settings, PriceBand, &l.priceBand); err != nil {
+        return
+    }
+    if err = decimalSetting(settings, MaxPosition, &l.maxPosition); err != nil {
+        return
+    }
+    if settings.HasSetting(MaxOpenOrders) {
+        l.maxOpenOrders, err = settings.IntSetting(MaxOpenOrders)
+    }
+    return
+}
+
+//newRiskRules reads the risk limits of the DEFAULT and SESSION settings and of the symbols of the risk section
+func newRiskRules(defaults *quickfix.SessionSettings, sessions map[quickfix.SessionID]*quickfix.SessionSettings, symbols map[string]string) (r *riskRules, err error) {
+    r = &riskRules{sessions: make(map[quickfix.SessionID]riskLimits), symbols: make(map[string]riskLimits)}
+
+    if r.defaults, err = newRiskLimits(defaults); err != nil {
+        return nil, err
+    }
+
+    for sessionID, settings := range sessions {
+        if r.sessions[sessionID], err = newRiskLimits(settings); err != nil {
+            return nil, fmt.Errorf("%v: %v", sessionID, err)
+        }
+    }
+
+    bySymbol, err := symbolSettings(riskSection, symbols)
+    if err != nil {
+        return nil, err
+    }
+
+    for symbol, settings := range bySymbol {
+        if r.symbols[symbol], err = newRiskLimits(settings); err != nil {
+            return nil, fmt.Errorf("[%v] %v: %v", riskSection, symbol, err)
+        }
+    }
+    return
+}
+
+//exposure is what a session already has in the market
+type exposure struct {
+    openOrders       int
+    openSymbolOrders int
+    position         decimal.Decimal
+    buying           decimal.Decimal
+    selling          decimal.Decimal
+}
+
+//exposureOf adds up the orders of the session of order other than except, the order of the executor that
+//order is or is about to replace
+func (e *executor) exposureOf(order *Order, except *Order) (x exposure) {
+    for _, o := range e.orders {
+        if o.SessionID != order.SessionID || o == except {
+            continue
+        }
+
+        working := o.isWorking()
+        if working {
+            x.openOrders++
+        }
+        if o.Symbol != order.Symbol {
+            continue
+        }
+
+        if o.Side == enum.Side_BUY {
+            x.position = x.position.Add(o.CumQty)
+        } else {
+            x.position = x.position.Sub(o.CumQty)
+        }
+
+        if !working {
+            continue
+        }
+        x.openSymbolOrders++
+        if o.Side == enum.Side_BUY {
+            x.buying = x.buying.Add(o.LeavesQty)
+        } else {
+            x.selling = x.selling.Add(o.LeavesQty)
+        }
+    }
+    return
+}
+
+//check returns why order breaches l, "" if it does not. openOrders is the number of orders already working
+//that count against MaxOpenOrders.
+func (l riskLimits) check(stock *Quote, order *Order, x exposure, openOrders int) string {
+    if l.maxOrderQty.Cmp(decimal.Zero) > 0 && order.OrderQty.Cmp(l.maxOrderQty) > 0 {
+        return fmt.Sprintf("OrderQty %v exceeds the limit of %v", order.OrderQty, l.maxOrderQty)
+    }
+
+    price := order.bookPrice()
+    if price.Cmp(decimal.Zero) <= 0 {
+        price = order.StopPx
+    }
+    if price.Cmp(decimal.Zero) <= 0 {
+        price = stock.trade.price
+    }
+    if notional := order.OrderQty.Mul(price); l.maxNotional.Cmp(decimal.Zero) > 0 && notional.Cmp(l.maxNotional) > 0 {
+        return fmt.Sprintf("Notional %v exceeds the limit of %v", notional, l.maxNotional)
+    }
+
+    limit := order.bookPrice()
+    if last := stock.trade.price; l.priceBand.Cmp(decimal.Zero) > 0 && limit.Cmp(decimal.Zero) > 0 && last.Cmp(decimal.Zero) > 0 {
+        band := last.Mul(l.priceBand).Div(decimal.New(100, 0))
+        if limit.Sub(last).Abs().Cmp(band) > 0 {
+            return fmt.Sprintf("Price %v is outside the %v%% band around %v", limit, l.priceBand, last)
+        }
+    }
+
+    if l.maxOpenOrders > 0 && openOrders >= l.maxOpenOrders {
+        return fmt.Sprintf("%v orders are already open, the limit is %v", openOrders, l.maxOpenOrders)
+    }
+
+    if l.maxPosition.Cmp(decimal.Zero) > 0 {
+        position := x.position.Add(x.buying).Add(order.LeavesQty)
+        if order.Side != enum.Side_BUY {
+            position = x.position.Sub(x.selling).Sub(order.LeavesQty)
+        }
+        if position.Abs().Cmp(l.maxPosition) > 0 {
+            return fmt.Sprintf("Position would reach %v, the limit is %v", position, l.maxPosition)
+        }
+    }
+
+    return ""
+}
+
+//checkRisk returns why order may not be accepted by the market of stock, "" if it passes every limit. except
+//is the order of the executor that order is or replaces, it does not count against the limits of order.
+func (e *executor) checkRisk(stock *Quote, order *Order, except *Order) string {
+    if e.risk == nil || order.isSynthetic() {
+        return ""
+    }
+
+    x := e.exposureOf(order, except)
+
+    session, ok := e.risk.sessions[order.SessionID]
+    if !ok {
+        session = e.risk.defaults
+    }
+    if text := session.check(stock, order, x, x.openOrders); text != "" {
+        return text
+    }
+
+    if symbol, ok := e.risk.symbols[order.Symbol]; ok {
+        return symbol.check(stock, order, x, x.openSymbolOrders)
+    }
+    return ""
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "strings"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+
+    "github.com/btasdoven/quickfixwebclient/acceptor/orderbook"
+)
+
+const (
+    //SelfTradePrevention is what happens to orders of one owner that would trade with each other: none,
+    //cancel-resting, cancel-aggressor, cancel-both or decrement-and-cancel. none if not set.
+    SelfTradePrevention string = "SelfTradePrevention"
+    //SelfTradePreventionKey is what makes orders of one owner, session or account. session if not set.
+    SelfTradePreventionKey string = "SelfTradePreventionKey"
+)
+
+var preventions = map[string]orderbook.Prevention{
+    "none":                 orderbook.AllowSelfTrade,
+    "cancel-resting":       orderbook.CancelResting,
+    "cancel-aggressor":     orderbook.CancelAggressor,
+    "cancel-both":          orderbook.CancelBoth,
+    "decrement-and-cancel": orderbook.DecrementAndCancel,
+}
+
+//selfTradeRule is the self-trade prevention of one session
+type selfTradeRule struct {
+    prevention orderbook.Prevention
+    byAccount  bool
+}
+
+//selfTradeRules are the self-trade preventions of every session, sessions not configured use the DEFAULT one
+type selfTradeRules struct {
+    defaults selfTradeRule
+    sessions map[quickfix.SessionID]selfTradeRule
+}
+
+func newSelfTradeRule(settings *quickfix.SessionSettings) (r selfTradeRule, err error) {
+    if settings.HasSetting(SelfTradePrevention) {
+        value, _ := settings.Setting(SelfTradePrevention)
+
+        var ok bool
+        if r.prevention, ok = preventions[strings.ToLower(value)]; !ok {
+            return r, quickfix.IncorrectFormatForSetting{Setting: SelfTradePrevention, Value: value}
+        }
+    }
+
+    if settings.HasSetting(SelfTradePreventionKey) {
+        value, _ := settings.Setting(SelfTradePreventionKey)
+
+        switch strings.ToLower(value) {
+        case "session":
+        case "account":
+            r.byAccount = true
+        default:
+            return r, quickfix.IncorrectFormatForSetting{Setting: SelfTradePreventionKey, Value: value}
+        }
+    }
+    return
+}
+
+//newSelfTradeRules reads the self-trade prevention of the DEFAULT and SESSION settings
+func newSelfTradeRules(defaults *quickfix.SessionSettings, sessions map[quickfix.SessionID]*quickfix.SessionSettings) (r *selfTradeRules, err error) {
+    r = &selfTradeRules{sessions: make(map[quickfix.SessionID]selfTradeRule)}
+
+    if r.defaults, err = newSelfTradeRule(defaults); err != nil {
+        return nil, err
+    }
+
+    for sessionID, settings := range sessions {
+        if r.sessions[sessionID], err = newSelfTradeRule(settings); err != nil {
+            return nil, err
+        }
+    }
+    return
+}
+
+//bookOrderOf is what the order book sees of order, with the owner and self-trade prevention of its session
+func (e *executor) bookOrderOf(order *Order) orderbook.Order {
+    b := order.bookOrder()
+    if e.selfTrade == nil || order.isSynthetic() {
+        return b
+    }
+
+    rule, ok := e.selfTrade.sessions[order.SessionID]
+    if !ok {
+        rule = e.selfTrade.defaults
+    }
+
+    b.Owner = order.SessionID.String()
+    if rule.byAccount {
+        b.Owner += "/" + order.Account
+    }
+    b.Prevention = rule.prevention
+    return b
+}
+
+//preventSelfTrade takes quantity off order, which the book kept from trading with an order of its own owner.
+//The order is cancelled if nothing is left of it and restated with a lower OrderQty otherwise.
+func (e *executor) preventSelfTrade(order *Order, quantity decimal.Decimal) {
+    if quantity.Cmp(decimal.Zero) <= 0 {
+        return
+    }
+
+    if quantity.Cmp(order.LeavesQty) >= 0 {
+        e.cancel(order)
+    } else {
+        order.OrderQty = order.OrderQty.Sub(quantity)
+        order.LeavesQty = order.LeavesQty.Sub(quantity)
+        order.LastPrice = decimal.Zero
+        order.LastShares = decimal.Zero
+        order.ExecTransType = enum.ExecTransType_NEW
+        order.ExecType = enum.ExecType_RESTATED
+        order.ExecID = e.genExecID().Value()
+
+        e.record(eventRestate, order)
+    }
+
+    if order.isSynthetic() {
+        return
+    }
+
+    e.printf("[SERVER]: Self-trade of %v prevented, %v taken off\n", order.ClOrdID, quantity)
+
+    execReport := newExecutionReport(order)
+    execReport.SetText("Self-trade prevented")
+    e.send(execReport, order.SessionID)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+)
+
+//isStop reports whether order is a stop or stop-limit order waiting for its trigger
+func (o *Order) isStop() bool {
+    return o.OrdType == enum.OrdType_STOP || o.OrdType == enum.OrdType_STOP_LIMIT
+}
+
+//isTriggeredBy reports whether a trade at the last price of stock elects the stop order
+func (o *Order) isTriggeredBy(stock *Quote) bool {
+    if stock.trade.price.Cmp(decimal.Zero) <= 0 {
+        return false
+    }
+
+    switch o.Side {
+    case enum.Side_BUY:
+        return stock.trade.price.Cmp(o.StopPx) >= 0
+    case enum.Side_SELL:
+        return stock.trade.price.Cmp(o.StopPx) <= 0
+    }
+    return false
+}
+
+//holdStop acknowledges a stop order and keeps it aside until the market trades through its StopPx
+func (e *executor) holdStop(order *Order) {
+    e.stops[order.Symbol] = append(e.stops[order.Symbol], order)
+    e.acknowledge(order)
+}
+
+//removeStop drops order from the pending stops, returns false if it was not pending
+func (e *executor) removeStop(order *Order) bool {
+    stops := e.stops[order.Symbol]
+    for i, o := range stops {
+        if o == order {
+            e.stops[order.Symbol] = append(stops[:i], stops[i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+func (e *executor) nextTriggeredStop(stock *Quote) *Order {
+    for _, o := range e.stops[stock.symbol] {
+        if o.isTriggeredBy(stock) {
+            e.removeStop(o)
+            return o
+        }
+    }
+    return nil
+}
+
+//clientOrdType returns the OrdType the owner of order gave it, a triggered stop is still a stop to its owner
+func (o *Order) clientOrdType() enum.OrdType {
+    switch {
+    case o.Triggered && o.OrdType == enum.OrdType_MARKET:
+        return enum.OrdType_STOP
+    case o.Triggered && o.OrdType == enum.OrdType_LIMIT:
+        return enum.OrdType_STOP_LIMIT
+    }
+    return o.OrdType
+}
+
+//triggerStops elects every pending stop crossed by the last trade of stock. Stops are converted
+//into market or limit orders, restated to their owner and matched in arrival order, each trade may
+//elect further stops.
+func (e *executor) triggerStops(stock *Quote) {
+    for order := e.nextTriggeredStop(stock); order != nil; order = e.nextTriggeredStop(stock) {
+        e.printf("[SERVER]: Stop %v triggered at %v\n", order.ClOrdID, stock.trade.price)
+
+        switch order.OrdType {
+        case enum.OrdType_STOP:
+            order.OrdType = enum.OrdType_MARKET
+        case enum.OrdType_STOP_LIMIT:
+            order.OrdType = enum.OrdType_LIMIT
+        }
+        order.Triggered = true
+        order.LastPrice = decimal.Zero
+        order.LastShares = decimal.Zero
+        order.ExecTransType = enum.ExecTransType_NEW
+        order.ExecType = enum.ExecType_RESTATED
+        order.ExecID = e.genExecID().Value()
+
+        e.record(eventTrigger, order)
+
+        execReport := newExecutionReport(order)
+        execReport.SetText("Stop triggered at " + stock.trade.price.String())
+        e.send(execReport, order.SessionID)
+
+        e.execute(stock, order)
+    }
+}
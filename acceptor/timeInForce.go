@@ -0,0 +1,184 @@
+package main
+
+import (
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/quickfixgo/quickfix/tag"
+    "github.com/shopspring/decimal"
+
+    fix42nos "github.com/quickfixgo/quickfix/fix42/newordersingle"
+)
+
+const (
+    //MarketCloseTime is the time of day, in MarketTimeZone, at which DAY orders expire
+    MarketCloseTime string = "MarketCloseTime"
+    //MarketTimeZone is the location MarketCloseTime is given in, UTC if not set
+    MarketTimeZone string = "MarketTimeZone"
+)
+
+//marketClose is the time of day at which the simulated market closes
+type marketClose struct {
+    offset   time.Duration
+    location *time.Location
+}
+
+func newMarketClose(settings *quickfix.SessionSettings) (c marketClose, err error) {
+    c.location = time.UTC
+    c.offset = 16 * time.Hour
+
+    if settings.HasSetting(MarketTimeZone) {
+        var zone string
+        if zone, err = settings.Setting(MarketTimeZone); err != nil {
+            return
+        }
+        if c.location, err = time.LoadLocation(zone); err != nil {
+            return
+        }
+    }
+
+    if settings.HasSetting(MarketCloseTime) {
+        c.offset, err = readTimeOfDay(settings, MarketCloseTime)
+    }
+
+    return
+}
+
+//on returns the close of the trading day falling on the given date
+func (c marketClose) on(year int, month time.Month, day int) time.Time {
+    return time.Date(year, month, day, 0, 0, 0, 0, c.location).Add(c.offset)
+}
+
+//next returns the first market close after now
+func (c marketClose) next(now time.Time) time.Time {
+    local := now.In(c.location)
+    end := c.on(local.Year(), local.Month(), local.Day())
+    if !now.Before(end) {
+        end = c.on(local.Year(), local.Month(), local.Day()+1)
+    }
+    return end
+}
+
+//readTimeInForce reads TimeInForce and the expiry it implies from msg. DAY is the FIX default.
+func (e *executor) readTimeInForce(msg fix42nos.NewOrderSingle, order *Order) (err quickfix.MessageRejectError) {
+    order.TimeInForce = enum.TimeInForce_DAY
+    if msg.HasTimeInForce() {
+        if order.TimeInForce, err = msg.GetTimeInForce(); err != nil {
+            return
+        }
+    }
+
+    switch order.TimeInForce {
+    case enum.TimeInForce_DAY:
+        order.ExpireTime = e.marketClose.next(e.now())
+
+    case enum.TimeInForce_GOOD_TILL_DATE:
+        switch {
+        case msg.HasExpireTime():
+            if order.ExpireTime, err = msg.GetExpireTime(); err != nil {
+                return
+            }
+        case msg.HasExpireDate():
+            expireDate, err := msg.GetExpireDate()
+            if err != nil {
+                return err
+            }
+
+            date, parseErr := time.Parse("20060102", expireDate)
+            if parseErr != nil {
+                return quickfix.IncorrectDataFormatForValue(tag.ExpireDate)
+            }
+            order.ExpireTime = e.marketClose.on(date.Year(), date.Month(), date.Day())
+        default:
+            return quickfix.ConditionallyRequiredFieldMissing(tag.ExpireTime)
+        }
+
+    //orders at the opening or at the close live as long as their auction
+    case enum.TimeInForce_GOOD_TILL_CANCEL, enum.TimeInForce_IMMEDIATE_OR_CANCEL, enum.TimeInForce_FILL_OR_KILL,
+        enum.TimeInForce_AT_THE_OPENING, enum.TimeInForce_AT_THE_CLOSE:
+
+    default:
+        return quickfix.ValueIsIncorrect(tag.TimeInForce)
+    }
+
+    return
+}
+
+//isImmediate reports whether whatever order cannot fill on arrival has to be cancelled instead of rested
+func (o *Order) isImmediate() bool {
+    return o.OrdType == enum.OrdType_MARKET ||
+        o.TimeInForce == enum.TimeInForce_IMMEDIATE_OR_CANCEL ||
+        o.TimeInForce == enum.TimeInForce_FILL_OR_KILL
+}
+
+//canFill reports whether order can fill what it is required to on arrival in the book of stock, see required.
+//Orders of its own owner the book would not let it trade with do not count.
+func (e *executor) canFill(stock *Quote, order *Order) bool {
+    required, _ := order.required()
+    if required.Cmp(decimal.Zero) <= 0 {
+        return true
+    }
+    return stock.book.Available(e.bookOrderOf(order)).Cmp(required) >= 0
+}
+
+//reject refuses order without it ever reaching the book
+func (e *executor) reject(order *Order, text string) {
+    order.LeavesQty = decimal.Zero
+    order.OrderStatus = enum.OrdStatus_REJECTED
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_REJECTED
+    order.ExecID = e.genExecID().Value()
+    order.Text = text
+}
+
+//expire takes order off the market once its TimeInForce has run out
+func (e *executor) expire(order *Order) {
+    if !e.removeStop(order) {
+        e.unbook(order)
+    }
+
+    order.LeavesQty = decimal.Zero
+    order.LastPrice = decimal.Zero
+    order.LastShares = decimal.Zero
+    order.OrderStatus = enum.OrdStatus_EXPIRED
+    order.ExecTransType = enum.ExecTransType_NEW
+    order.ExecType = enum.ExecType_EXPIRED
+    order.ExecID = e.genExecID().Value()
+
+    e.record(eventExpire, order)
+}
+
+//expireOrders moves every symbol on to its trading phase at now and expires every working DAY and GTD order
+//whose expiry is not after now
+func (e *executor) expireOrders(now time.Time) {
+    e.lock.Lock()
+    defer e.lock.Unlock()
+
+    //a closing auction uncrosses before the DAY orders expire
+    e.updatePhases(now)
+
+    for _, order := range e.orders {
+        if !order.isWorking() || order.ExpireTime.IsZero() || now.Before(order.ExpireTime) {
+            continue
+        }
+
+        e.printf("[SERVER]: Order %v expired at %v\n", order.ClOrdID, order.ExpireTime)
+
+        e.expire(order)
+        e.send(newExecutionReport(order), order.SessionID)
+    }
+
+    for _, stock := range e.quotes {
+        e.repeg(stock)
+    }
+
+    e.publishMarketData()
+}
+
+//sweepExpiredOrders checks for expired orders every interval, it never returns
+func (e *executor) sweepExpiredOrders(interval time.Duration) {
+    for now := range time.Tick(interval) {
+        e.expireOrders(now)
+    }
+}
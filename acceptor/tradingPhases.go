@@ -0,0 +1,343 @@
+package main
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/quickfixgo/quickfix"
+    "github.com/quickfixgo/quickfix/enum"
+    "github.com/shopspring/decimal"
+)
+
+const (
+    //PreOpenTime is the time of day, in MarketTimeZone, orders start to be collected for the opening auction.
+    //Without it they are collected from the close of the day before.
+    PreOpenTime string = "PreOpenTime"
+    //OpenTime is the time of day the opening auction uncrosses and continuous trading starts, a symbol without
+    //an OpenTime trades continuously all day
+    OpenTime string = "OpenTime"
+    //ClosingAuctionTime is the time of day continuous trading stops and orders are collected for the closing
+    //auction, which uncrosses at MarketCloseTime. Without it there is no closing auction.
+    ClosingAuctionTime string = "ClosingAuctionTime"
+)
+
+//phasesSection is the acceptor.cfg section holding the trading phases of single symbols, as Symbol.Setting=value
+const phasesSection = "PHASES"
+
+//phase is the trading phase a symbol is in
+type phase int
+
+const (
+    continuous phase = iota
+    preOpen
+    closingCall
+    closed
+)
+
+func (p phase) String() string {
+    switch p {
+    case preOpen:
+        return "pre-open"
+    case closingCall:
+        return "closing call"
+    case closed:
+        return "closed"
+    }
+    return "continuous trading"
+}
+
+//isCall reports whether orders rest without matching in p, they only trade continuously
+func (p phase) isCall() bool {
+    return p != continuous
+}
+
+//isAuction reports whether orders are collected for an auction in p
+func (p phase) isAuction() bool {
+    return p == preOpen || p == closingCall
+}
+
+//schedule is the trading day of a symbol, every time is an offset from midnight in the market time zone
+type schedule struct {
+    preOpen        time.Duration
+    hasPreOpen     bool
+    open           time.Duration
+    closingAuction time.Duration
+    close          time.Duration
+    location       *time.Location
+}
+
+//readTimeOfDay reads setting as a time of day, returning how long after midnight it is
+func readTimeOfDay(settings *quickfix.SessionSettings, setting string) (time.Duration, error) {
+    value, err := settings.Setting(setting)
+    if err != nil {
+        return 0, err
+    }
+
+    t, err := time.Parse("15:04:05", value)
+    if err != nil {
+        return 0, quickfix.IncorrectFormatForSetting{Setting: setting, Value: value, Err: err}
+    }
+    return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second, nil
+}
+
+//newSchedule reads a schedule closing at c, every setting is taken from the first of layers holding it. It
+//returns nil for symbols without an OpenTime.
+func newSchedule(c marketClose, layers ...*quickfix.SessionSettings) (s *schedule, err error) {
+    lookup := func(setting string) *quickfix.SessionSettings {
+        for _, settings := range layers {
+            if settings != nil && settings.HasSetting(setting) {
+                return settings
+            }
+        }
+        return nil
+    }
+
+    settings := lookup(OpenTime)
+    if settings == nil {
+        return nil, nil
+    }
+
+    s = &schedule{close: c.offset, closingAuction: c.offset, location: c.location}
+    if s.open, err = readTimeOfDay(settings, OpenTime); err != nil {
+        return nil, err
+    }
+
+    if settings = lookup(PreOpenTime); settings != nil {
+        s.hasPreOpen = true
+        if s.preOpen, err = readTimeOfDay(settings, PreOpenTime); err != nil {
+            return nil, err
+        }
+    }
+
+    if settings = lookup(ClosingAuctionTime); settings != nil {
+        if s.closingAuction, err = readTimeOfDay(settings, ClosingAuctionTime); err != nil {
+            return nil, err
+        }
+    }
+
+    if s.hasPreOpen && s.preOpen > s.open || s.open >= s.closingAuction || s.closingAuction > s.close {
+        return nil, fmt.Errorf("expected %v, %v, %v and %v in this order", PreOpenTime, OpenTime, ClosingAuctionTime, MarketCloseTime)
+    }
+    return s, nil
+}
+
+//at returns the phase of the trading day at now. A nil schedule trades continuously.
+func (s *schedule) at(now time.Time) phase {
+    if s == nil {
+        return continuous
+    }
+
+    local := now.In(s.location)
+    offset := local.Sub(time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, s.location))
+
+    switch {
+    case s.hasPreOpen && offset < s.preOpen:
+        return closed
+    case offset < s.open:
+        return preOpen
+    case offset < s.closingAuction:
+        return continuous
+    case offset < s.close:
+        return closingCall
+    case s.hasPreOpen:
+        return closed
+    }
+    return preOpen
+}
+
+//hasClosingAuction reports whether orders are collected for an auction before the close
+func (s *schedule) hasClosingAuction() bool {
+    return s != nil && s.closingAuction < s.close
+}
+
+//phaseRules are the schedules of the symbols, those of the DEFAULT settings unless a symbol has its own
+type phaseRules struct {
+    defaults *schedule
+    symbols  map[string]*schedule
+}
+
+//newPhaseRules reads the schedules of the DEFAULT settings and of the symbols of the phases section, a symbol
+//takes what it does not set from the DEFAULT settings
+func newPhaseRules(defaults *quickfix.SessionSettings, symbols map[string]string, c marketClose) (r *phaseRules, err error) {
+    r = &phaseRules{symbols: make(map[string]*schedule)}
+
+    if r.defaults, err = newSchedule(c, defaults); err != nil {
+        return nil, err
+    }
+
+    bySymbol, err := symbolSettings(phasesSection, symbols)
+    if err != nil {
+        return nil, err
+    }
+
+    for symbol, settings := range bySymbol {
+        if r.symbols[symbol], err = newSchedule(c, settings, defaults); err != nil {
+            return nil, fmt.Errorf("[%v] %v: %v", phasesSection, symbol, err)
+        }
+    }
+    return
+}
+
+//of returns the schedule of symbol, nil if it trades continuously
+func (r *phaseRules) of(symbol string) *schedule {
+    if r == nil {
+        return nil
+    }
+    if s, ok := r.symbols[symbol]; ok {
+        return s
+    }
+    return r.defaults
+}
+
+//checkPhase returns why order may not enter the market of stock in its current phase, "" if it may
+func (e *executor) checkPhase(stock *Quote, order *Order) (string, enum.OrdRejReason) {
+    switch {
+    case stock.phase == closed:
+        return "Market is closed", enum.OrdRejReason_EXCHANGE_CLOSED
+    case order.TimeInForce == enum.TimeInForce_AT_THE_OPENING && stock.schedule == nil:
+        return "No opening auction for " + stock.symbol, enum.OrdRejReason_BROKER
+    case order.TimeInForce == enum.TimeInForce_AT_THE_OPENING && stock.phase != preOpen:
+        return "Opening auction is over", enum.OrdRejReason_TOO_LATE_TO_ENTER
+    case order.TimeInForce == enum.TimeInForce_AT_THE_CLOSE && !stock.schedule.hasClosingAuction():
+        return "No closing auction for " + stock.symbol, enum.OrdRejReason_BROKER
+    }
+    return "", ""
+}
+
+//holdForClose acknowledges an order at the close and keeps it aside until the closing call starts
+func (e *executor) holdForClose(order *Order) {
+    e.closeOrders[order.Symbol] = append(e.closeOrders[order.Symbol], order)
+    e.acknowledge(order)
+}
+
+//updatePhases moves every symbol on to its phase at now
+func (e *executor) updatePhases(now time.Time) {
+    for _, stock := range e.quotes {
+        e.setPhase(stock, stock.schedule.at(now))
+    }
+}
+
+//setPhase moves stock to phase p. Leaving an auction uncrosses the book, which is also uncrossed whenever it
+//has to match again, for instance once the books of a journal have been rebuilt. Orders at the opening or
+//at the close expire with their auction, orders at the close wait for the closing call.
+func (e *executor) setPhase(stock *Quote, p phase) {
+    old := stock.phase
+    if p == old && p.isCall() == stock.book.InCall() {
+        return
+    }
+    stock.phase = p
+
+    e.printf("[SERVER]: %v enters %v\n", stock.symbol, p)
+
+    if old.isAuction() && p != old || !p.isCall() && stock.book.InCall() {
+        e.uncross(stock, old)
+    }
+
+    if p != preOpen {
+        e.endAuction(stock, enum.TimeInForce_AT_THE_OPENING)
+    }
+    if p == preOpen || p == closed {
+        e.endAuction(stock, enum.TimeInForce_AT_THE_CLOSE)
+    }
+
+    switch p {
+    case preOpen:
+        stock.open = mdLevel{}
+    case closingCall:
+        stock.close = mdLevel{}
+    }
+
+    if p.isCall() {
+        stock.book.Call()
+    }
+
+    if p == closingCall {
+        for _, order := range e.closeOrders[stock.symbol] {
+            if _, booked := e.resting[order.OrderID]; order.isWorking() && !booked {
+                e.execute(stock, order)
+            }
+        }
+        e.closeOrders[stock.symbol] = nil
+    }
+
+    e.triggerStops(stock)
+    e.repeg(stock)
+}
+
+//uncross ends the call of stock, old is the phase it was in. Market orders only wait for an auction, what
+//they did not fill there is cancelled.
+func (e *executor) uncross(stock *Quote, old phase) {
+    fills := stock.book.Uncross(stock.trade.price)
+
+    result := mdLevel{price: decimal.Zero, size: decimal.Zero}
+    for _, fill := range fills {
+        e.recordTrade(stock, fill)
+        result = mdLevel{price: fill.Price, size: result.size.Add(fill.Quantity)}
+        stock.trade = mdLevel{price: fill.Price, size: fill.Quantity}
+
+        for _, id := range []string{fill.Aggressor, fill.Resting} {
+            if order, ok := e.resting[id]; ok {
+                order.Process(fill.Price, fill.Quantity)
+                e.sendFill(order)
+
+                if order.OrderStatus == enum.OrdStatus_FILLED {
+                    delete(e.resting, id)
+                }
+            }
+        }
+    }
+
+    switch old {
+    case preOpen:
+        stock.open = result
+    case closingCall:
+        stock.close = result
+    }
+    if len(fills) > 0 {
+        e.printf("[SERVER]: %v uncrossed %v at %v\n", stock.symbol, result.size, result.price)
+    }
+
+    for _, order := range e.orders {
+        if _, booked := e.resting[order.OrderID]; booked && order.Symbol == stock.symbol && order.OrdType == enum.OrdType_MARKET {
+            e.unbook(order)
+            e.cancel(order)
+            order.Text = "Not filled in the auction"
+            e.send(newExecutionReport(order), order.SessionID)
+        }
+    }
+}
+
+//endAuction expires the working orders of stock whose TimeInForce ties them to an auction that is over
+func (e *executor) endAuction(stock *Quote, timeInForce enum.TimeInForce) {
+    for _, order := range e.orders {
+        if order.Symbol != stock.symbol || order.TimeInForce != timeInForce || !order.isWorking() {
+            continue
+        }
+
+        e.printf("[SERVER]: Order %v expired with its auction\n", order.ClOrdID)
+
+        e.expire(order)
+        e.send(newExecutionReport(order), order.SessionID)
+    }
+}
+
+//auction returns the opening or closing price of stock and its volume: while orders are collected for the
+//auction the price it would uncross at now, once it has uncrossed the price it did
+func (q *Quote) auction(entryType enum.MDEntryType) []mdLevel {
+    collecting, result := preOpen, q.open
+    if entryType == enum.MDEntryType_CLOSING_PRICE {
+        collecting, result = closingCall, q.close
+    }
+
+    if q.phase == collecting {
+        if price, volume := q.book.Indicative(q.trade.price); volume.Cmp(decimal.Zero) > 0 {
+            return []mdLevel{{price: price, size: volume}}
+        }
+        return nil
+    }
+
+    if result.size.Cmp(decimal.Zero) > 0 {
+        return []mdLevel{result}
+    }
+    return nil
+}
@@ -18,7 +18,8 @@ type Initiator struct {
     *quickfix.MessageRouter
     Initiator *quickfix.Initiator
     Callbacks map[string]chan interface{}
-    lock sync.RWMutex
+    //lock is shared by every copy of the Initiator, the queries run on copies of it
+    lock *sync.RWMutex
 }
 
 func NewInitiator() (app Initiator) {
@@ -41,7 +42,7 @@ func NewInitiator() (app Initiator) {
         return
     }
 
-    app = Initiator{MessageRouter: quickfix.NewMessageRouter(), Callbacks: make(map[string]chan interface{})}
+    app = Initiator{MessageRouter: quickfix.NewMessageRouter(), Callbacks: make(map[string]chan interface{}), lock: &sync.RWMutex{}}
 
     app.AddRoute(fix42md.Route(app.OnFIX42MarketData))
     app.AddRoute(fix42er.Route(app.OnFIX42ExecutionReport))
@@ -17,12 +17,39 @@ import (
 func (e *Initiator) OnFIX42ExecutionReport(msg fix42er.ExecutionReport, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
     orderId, _ := msg.GetClOrdID()
 
+    //an order gets several reports, only deliver the first one to whoever is still waiting for it
     e.lock.Lock()
-    e.Callbacks[orderId] <- msg
+    if callback, ok := e.Callbacks[orderId]; ok {
+        select {
+        case callback <- msg:
+        default:
+        }
+    }
     e.lock.Unlock()
     return
 }
 
+//awaitReport registers the channel the first report for orderId is delivered to. It is buffered, so a report
+//arriving before anyone waits on it is kept.
+func (e Initiator) awaitReport(orderId string) chan interface{} {
+    callback := make(chan interface{}, 1)
+    e.lock.Lock()
+    e.Callbacks[orderId] = callback
+    e.lock.Unlock()
+    return callback
+}
+
+//stopReports stops delivering the reports for orderId to callback. It holds the lock reports are delivered
+//under, so none is ever sent on the closed channel.
+func (e Initiator) stopReports(orderId string, callback chan interface{}) {
+    e.lock.Lock()
+    if e.Callbacks[orderId] == callback {
+        delete(e.Callbacks, orderId)
+    }
+    close(callback)
+    e.lock.Unlock()
+}
+
 func (e Initiator) QueryOrderSingleRequest(
     orderId string,
     symbol string,
@@ -42,16 +69,14 @@ func (e Initiator) QueryOrderSingleRequest(
 
     queryHeader(request.Header)
 
-    e.lock.Lock()
-    e.Callbacks[orderId] = make(chan interface{})
-    e.lock.Unlock()
-    defer close(e.Callbacks[orderId])
-    defer delete(e.Callbacks, orderId)
+    callback := e.awaitReport(orderId)
+    defer e.stopReports(orderId, callback)
 
     go quickfix.Send(request)
 
+    //the acknowledgment, or the reject, comes first, the fills after it show in the status of the order
     fmt.Printf("\tQueryOrderSingleRequest Waiting response for request %+v", request)
-    res := (<- e.Callbacks[orderId]).(fix42er.ExecutionReport)
+    res := (<- callback).(fix42er.ExecutionReport)
     fmt.Printf("\tQueryOrderSingleRequest Response recieved: %+v %+v", orderId, res)
 
     return res
@@ -65,16 +90,13 @@ func (e Initiator) QueryOrderStatusRequest(orderId string, symbol string, side e
 
     queryHeader(request.Header)
 
-    e.lock.Lock()
-    e.Callbacks[orderId] = make(chan interface{})
-    e.lock.Unlock()
-    defer close(e.Callbacks[orderId])
-    defer delete(e.Callbacks, orderId)
+    callback := e.awaitReport(orderId)
+    defer e.stopReports(orderId, callback)
 
     go quickfix.Send(request)
 
     fmt.Printf("\tQueryOrderStatusRequest Waiting response for request %+v", request)
-    res := (<- e.Callbacks[orderId]).(fix42er.ExecutionReport)
+    res := (<- callback).(fix42er.ExecutionReport)
     fmt.Printf("\tQueryOrderStatusRequest Response recieved: %+v %+v", orderId, res)
 
     return res